@@ -20,14 +20,25 @@ func main() {
 
 	app.Commands = []cli.Command{
 		command.RunCommand,
+		command.CreateCommand,
+		command.StartCommand,
+		command.SpecCommand,
 		command.InitCommand,
 		command.CommitCommand,
 		command.ListCommand,
 		command.LogCommand,
 		command.ExecCommand,
 		command.StopCommand,
+		command.KillCommand,
 		command.RemoveCommand,
 		command.NetworkCommand,
+		command.InspectCommand,
+		command.StateCommand,
+		command.UpdateCommand,
+		command.StatsCommand,
+		command.PauseCommand,
+		command.UnpauseCommand,
+		command.WaitCommand,
 	}
 
 	app.Before = func(context *cli.Context) error {
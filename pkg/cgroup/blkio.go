@@ -0,0 +1,256 @@
+package cgroup
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wangao1236/my-runc/pkg/util"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	minBlkioWeight = 10
+	maxBlkioWeight = 1000
+)
+
+var _ Subsystem = &BlkioSubsystem{}
+
+// ThrottleDevice 表示对某一个块设备的 IO 限速，Rate 的单位为 bytes/s 或 IO 次数/s
+type ThrottleDevice struct {
+	Path  string `json:"path"`
+	Major int64  `json:"major"`
+	Minor int64  `json:"minor"`
+	Rate  uint64 `json:"rate"`
+}
+
+// NewThrottleDevice 根据块设备的路径（如 /dev/sda）解析出设备号
+func NewThrottleDevice(devicePath string, rate uint64) (*ThrottleDevice, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(devicePath, &st); err != nil {
+		logrus.Errorf("failed to stat device %v: %v", devicePath, err)
+		return nil, err
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFBLK {
+		return nil, fmt.Errorf("%v is not a block device", devicePath)
+	}
+	return &ThrottleDevice{
+		Path:  devicePath,
+		Major: int64(unix.Major(st.Rdev)),
+		Minor: int64(unix.Minor(st.Rdev)),
+		Rate:  rate,
+	}, nil
+}
+
+// DeviceID 返回 major:minor 形式的设备号
+func (d *ThrottleDevice) DeviceID() string {
+	return fmt.Sprintf("%v:%v", d.Major, d.Minor)
+}
+
+// String 返回写入 blkio.throttle.* 的格式，如：8:0 1048576
+func (d *ThrottleDevice) String() string {
+	return fmt.Sprintf("%v %v", d.DeviceID(), d.Rate)
+}
+
+// BlkioSubsystem 限制块设备 IO：cgroup v1 下使用 blkio controller，cgroup v2 下使用 io controller
+type BlkioSubsystem struct {
+}
+
+func (s *BlkioSubsystem) Name() string {
+	return "blkio"
+}
+
+func (s *BlkioSubsystem) Set(cgroupName string, res *ResourceConfig) error {
+	if res.BlkioWeight == 0 && len(res.BlkioThrottleReadBpsDevice) == 0 &&
+		len(res.BlkioThrottleWriteBpsDevice) == 0 && len(res.BlkioThrottleReadIOPSDevice) == 0 &&
+		len(res.BlkioThrottleWriteIOPSDevice) == 0 {
+		return nil
+	}
+	if res.BlkioWeight != 0 && (res.BlkioWeight < minBlkioWeight || res.BlkioWeight > maxBlkioWeight) {
+		return fmt.Errorf("blkio weight %v out of range [%v, %v]", res.BlkioWeight, minBlkioWeight, maxBlkioWeight)
+	}
+
+	cgroupPath, err := getCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("path of cgroup (%v) in (%v) is %v", cgroupName, s.Name(), cgroupPath)
+
+	if util.IsCgroup2UnifiedMode() {
+		return s.setUnified(cgroupPath, cgroupName, res)
+	}
+
+	if res.BlkioWeight != 0 {
+		// 使用 BFQ 调度器的内核只提供 blkio.bfq.weight
+		weightFile := "blkio.weight"
+		if _, err = os.Stat(path.Join(cgroupPath, weightFile)); os.IsNotExist(err) {
+			weightFile = "blkio.bfq.weight"
+		}
+		if _, err = os.Stat(path.Join(cgroupPath, weightFile)); err != nil {
+			return fmt.Errorf("blkio weight is not supported by the kernel: %v", err)
+		}
+		if err = writeCgroupFile(cgroupPath, weightFile, strconv.Itoa(int(res.BlkioWeight))); err != nil {
+			return fmt.Errorf("failed to set blkio weight of %v: %v", cgroupName, err)
+		}
+	}
+	throttles := map[string][]*ThrottleDevice{
+		"blkio.throttle.read_bps_device":   res.BlkioThrottleReadBpsDevice,
+		"blkio.throttle.write_bps_device":  res.BlkioThrottleWriteBpsDevice,
+		"blkio.throttle.read_iops_device":  res.BlkioThrottleReadIOPSDevice,
+		"blkio.throttle.write_iops_device": res.BlkioThrottleWriteIOPSDevice,
+	}
+	for file, devices := range throttles {
+		// 每次写入只能设置一个设备
+		for _, device := range devices {
+			if err = writeCgroupFile(cgroupPath, file, device.String()); err != nil {
+				return fmt.Errorf("failed to set %v of %v for %v: %v", file, device.Path, cgroupName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// setUnified 设置 cgroup v2 下的 io 限制：blkio weight 换算为 io.weight，同一设备的各项限速合并写入 io.max
+func (s *BlkioSubsystem) setUnified(cgroupPath, cgroupName string, res *ResourceConfig) error {
+	if res.BlkioWeight != 0 {
+		weight := "default " + strconv.FormatUint(blkioWeightToIOWeight(res.BlkioWeight), 10)
+		if err := writeCgroupFile(cgroupPath, "io.weight", weight); err != nil {
+			return fmt.Errorf("failed to set io weight of %v: %v", cgroupName, err)
+		}
+	}
+
+	for _, limit := range unifiedIOMaxLimits(res) {
+		if err := writeCgroupFile(cgroupPath, "io.max", limit); err != nil {
+			return fmt.Errorf("failed to set io max (%v) of %v: %v", limit, cgroupName, err)
+		}
+	}
+	return nil
+}
+
+// unifiedIOMaxLimits 将块设备限速合并为 io.max 的配置，每个设备一行，如：8:0 rbps=1048576 wiops=100。
+// 同一个设备的多次写入会覆盖之前未指定的项，因此同一个设备的所有限速需要在一行中写入
+func unifiedIOMaxLimits(res *ResourceConfig) []string {
+	var deviceIDs []string
+	limits := make(map[string][]string)
+	throttles := []struct {
+		key     string
+		devices []*ThrottleDevice
+	}{
+		{"rbps", res.BlkioThrottleReadBpsDevice},
+		{"wbps", res.BlkioThrottleWriteBpsDevice},
+		{"riops", res.BlkioThrottleReadIOPSDevice},
+		{"wiops", res.BlkioThrottleWriteIOPSDevice},
+	}
+	for _, throttle := range throttles {
+		for _, device := range throttle.devices {
+			id := device.DeviceID()
+			if _, ok := limits[id]; !ok {
+				deviceIDs = append(deviceIDs, id)
+			}
+			limits[id] = append(limits[id], fmt.Sprintf("%v=%v", throttle.key, device.Rate))
+		}
+	}
+	var result []string
+	for _, id := range deviceIDs {
+		result = append(result, id+" "+strings.Join(limits[id], " "))
+	}
+	return result
+}
+
+func (s *BlkioSubsystem) Apply(cgroupName string, pid int) error {
+	if !controllerMounted(s.Name()) {
+		logrus.Infof("cgroup controller %v is not mounted, skip it", s.Name())
+		return nil
+	}
+	cgroupPath, err := getCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("path of cgroup (%v) in (%v) is %v", cgroupName, s.Name(), cgroupPath)
+
+	// 将 PID 加入该 cgroup
+	if err = writeCgroupFile(cgroupPath, "cgroup.procs", strconv.Itoa(pid)); err != nil {
+		return fmt.Errorf("failed to add pid to %v: %v", cgroupName, err)
+	}
+	return nil
+}
+
+func (s *BlkioSubsystem) Remove(cgroupName string) error {
+	if !controllerMounted(s.Name()) {
+		return nil
+	}
+	cgroupPath, err := getCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("try to remove path of cgroup (%v) in (%v) is %v", cgroupName, s.Name(), cgroupPath)
+	return os.RemoveAll(cgroupPath)
+}
+
+func (s *BlkioSubsystem) GetStats(cgroupName string, stats *Stats) error {
+	cgroupPath, err := findCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		return err
+	}
+	if util.IsCgroup2UnifiedMode() {
+		return s.getUnifiedStats(cgroupPath, stats)
+	}
+
+	// 每行的格式为 "8:0 Read 4096"，最后一行为 "Total 8192"
+	var body string
+	if body, err = readCgroupFile(cgroupPath, "blkio.throttle.io_service_bytes_recursive"); err != nil {
+		return err
+	}
+	for _, line := range strings.Split(body, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		value, parseErr := strconv.ParseUint(fields[2], 10, 64)
+		if parseErr != nil {
+			continue
+		}
+		switch fields[1] {
+		case "Read":
+			stats.Blkio.ReadBytes += value
+		case "Write":
+			stats.Blkio.WriteBytes += value
+		}
+	}
+	return nil
+}
+
+// getUnifiedStats 读取 cgroup v2 的 io.stat，每行的格式为 "8:0 rbytes=4096 wbytes=0 rios=1 wios=0 ..."
+func (s *BlkioSubsystem) getUnifiedStats(cgroupPath string, stats *Stats) error {
+	body, err := readCgroupFile(cgroupPath, "io.stat")
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(body, "\n") {
+		for _, field := range strings.Fields(line) {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			value, parseErr := strconv.ParseUint(kv[1], 10, 64)
+			if parseErr != nil {
+				continue
+			}
+			switch kv[0] {
+			case "rbytes":
+				stats.Blkio.ReadBytes += value
+			case "wbytes":
+				stats.Blkio.WriteBytes += value
+			}
+		}
+	}
+	return nil
+}
+
+// blkioWeightToIOWeight 将 cgroup v1 的 blkio weight [10, 1000] 线性映射为 cgroup v2 的 io.weight [1, 10000]
+func blkioWeightToIOWeight(weight uint16) uint64 {
+	return 1 + (uint64(weight)-minBlkioWeight)*9999/(maxBlkioWeight-minBlkioWeight)
+}
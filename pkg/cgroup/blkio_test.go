@@ -0,0 +1,57 @@
+package cgroup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlkioWeightToIOWeight(t *testing.T) {
+	tests := []struct {
+		weight   uint16
+		ioWeight uint64
+	}{
+		{weight: 10, ioWeight: 1},
+		{weight: 100, ioWeight: 910},
+		{weight: 500, ioWeight: 4950},
+		{weight: 1000, ioWeight: 10000},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.ioWeight, blkioWeightToIOWeight(test.weight), test.weight)
+	}
+}
+
+func TestUnifiedIOMaxLimits(t *testing.T) {
+	sda := func(rate uint64) *ThrottleDevice {
+		return &ThrottleDevice{Path: "/dev/sda", Major: 8, Minor: 0, Rate: rate}
+	}
+	sdb := func(rate uint64) *ThrottleDevice {
+		return &ThrottleDevice{Path: "/dev/sdb", Major: 8, Minor: 16, Rate: rate}
+	}
+	tests := []struct {
+		name   string
+		res    *ResourceConfig
+		limits []string
+	}{
+		{name: "no throttle", res: &ResourceConfig{BlkioWeight: 500}},
+		{
+			name:   "single limit",
+			res:    &ResourceConfig{BlkioThrottleReadBpsDevice: []*ThrottleDevice{sda(1048576)}},
+			limits: []string{"8:0 rbps=1048576"},
+		},
+		{
+			// 同一个设备的所有限速合并为一行，设备按照第一次出现的顺序输出
+			name: "merge limits of the same device",
+			res: &ResourceConfig{
+				BlkioThrottleReadBpsDevice:   []*ThrottleDevice{sda(1048576)},
+				BlkioThrottleWriteBpsDevice:  []*ThrottleDevice{sdb(2097152), sda(2097152)},
+				BlkioThrottleReadIOPSDevice:  []*ThrottleDevice{sdb(200)},
+				BlkioThrottleWriteIOPSDevice: []*ThrottleDevice{sda(100)},
+			},
+			limits: []string{"8:0 rbps=1048576 wbps=2097152 wiops=100", "8:16 wbps=2097152 riops=200"},
+		},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.limits, unifiedIOMaxLimits(test.res), test.name)
+	}
+}
@@ -1,33 +1,85 @@
 package cgroup
 
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wangao1236/my-runc/pkg/util"
+)
+
 var (
 	Subsystems = []Subsystem{
 		&MemorySubsystem{},
+		&CPUSubsystem{},
+		&CpuacctSubsystem{},
+		&CpusetSubsystem{},
+		&PidsSubsystem{},
+		&BlkioSubsystem{},
+		&FreezerSubsystem{},
+		&DevicesSubsystem{},
+		&HugetlbSubsystem{},
 	}
 )
 
 type ResourceConfig struct {
-	MemoryLimit string
-	CPUShare    string
-	CPUSet      string
+	MemoryLimit string `json:"memoryLimit,omitempty"`
+	CPUShare    string `json:"cpuShare,omitempty"`
+	CPUSet      string `json:"cpuSet,omitempty"`
+	// CPUSetMems 表示允许使用的 NUMA 内存节点，为空时从父 cgroup 继承
+	CPUSetMems string `json:"cpuSetMems,omitempty"`
+	// CPUQuota 和 CPUPeriod 对应 CFS 带宽控制：每 CPUPeriod 微秒内最多使用 CPUQuota 微秒的 CPU 时间，-1 表示不限制
+	CPUQuota  int64  `json:"cpuQuota,omitempty"`
+	CPUPeriod uint64 `json:"cpuPeriod,omitempty"`
+	// PidsLimit 表示 cgroup 内最多可以有多少个进程，0 表示不设置，-1 表示不限制
+	PidsLimit int64 `json:"pidsLimit,omitempty"`
+	// BlkioWeight 表示块设备 IO 的相对权重，取值 [10, 1000]，0 表示不设置
+	BlkioWeight uint16 `json:"blkioWeight,omitempty"`
+	// 以下为每个块设备的 IO 限速，单位分别为 bytes/s 和 IO 次数/s
+	BlkioThrottleReadBpsDevice   []*ThrottleDevice `json:"blkioThrottleReadBpsDevice,omitempty"`
+	BlkioThrottleWriteBpsDevice  []*ThrottleDevice `json:"blkioThrottleWriteBpsDevice,omitempty"`
+	BlkioThrottleReadIOPSDevice  []*ThrottleDevice `json:"blkioThrottleReadIOPSDevice,omitempty"`
+	BlkioThrottleWriteIOPSDevice []*ThrottleDevice `json:"blkioThrottleWriteIOPSDevice,omitempty"`
+	// MemorySwap 表示内存与 swap 的总上限，必须不小于 MemoryLimit，-1 表示不限制 swap
+	MemorySwap string `json:"memorySwap,omitempty"`
+	// OOMKillDisable 为 true 时内存超限的进程会被挂起而不是被 OOM killer 杀死，只在 cgroup v1 下支持
+	OOMKillDisable bool `json:"oomKillDisable,omitempty"`
+	// Devices 是容器可以访问的设备白名单，为空时不做限制
+	Devices []*Device `json:"devices,omitempty"`
+	// HugetlbLimit 是每种大小的大页的使用上限，单位为字节
+	HugetlbLimit []*HugepageLimit `json:"hugetlbLimit,omitempty"`
 }
 
-// Subsystem 对应 linux cgroup 的每一个 subsystem：
+// Subsystem 对应 linux cgroup 的每一个 subsystem，Set 需要同时支持 cgroup v1 和 v2，Apply 和 Remove 只在 cgroup v1 下使用：
 type Subsystem interface {
 	// Name 返回 名称，如 memory、cpuset、cpushare
 	Name() string
 	// Set 写入配置文件，对资源进行限制
 	Set(cgroupName string, res *ResourceConfig) error
-	// Apply 将 PID 加入当前 Cgroup
+	// Apply 将 PID 所在的进程加入当前 Cgroup，写入 cgroup.procs 而不是 tasks，以便同时移动多线程进程的所有线程
 	Apply(cgroupName string, pid int) error
 	// Remove 将 PID 移出当前 Cgroup
 	Remove(cgroupName string) error
+	// GetStats 读取当前 Cgroup 的资源使用情况，写入 stats 中
+	GetStats(cgroupName string, stats *Stats) error
 }
 
+const (
+	// DriverCgroupfs 表示直接读写 /sys/fs/cgroup 管理 cgroup
+	DriverCgroupfs = "cgroupfs"
+	// DriverSystemd 表示通过 D-Bus 让 systemd 为每个容器创建 transient scope unit
+	DriverSystemd = "systemd"
+)
+
 type Manager struct {
 	// CgroupName 表示当前进程的 Cgroup 名称：在 Cgroup 下建立的子文件夹名称
 	CgroupName string
 	Resource   *ResourceConfig
+	// Driver 是 DriverCgroupfs 或 DriverSystemd，为空时等同于 DriverCgroupfs
+	Driver string
 }
 
 func NewManager(cgroupName string) *Manager {
@@ -36,8 +88,44 @@ func NewManager(cgroupName string) *Manager {
 	}
 }
 
+// NewSystemdManager 返回使用 systemd 管理的 Manager，cgroupName 的格式为 ${slice-path}/${scope}，
+// 如：my-runc.slice/my-runc-1281457058.scope
+func NewSystemdManager(cgroupName string) *Manager {
+	return &Manager{
+		CgroupName: cgroupName,
+		Driver:     DriverSystemd,
+	}
+}
+
 // Apply 将 PID 加入Cgroup
 func (m *Manager) Apply(pid int) error {
+	if m.Driver == DriverSystemd {
+		return m.systemdApply(pid)
+	}
+	return m.fsApply(pid)
+}
+
+// Set 设置资源限制
+func (m *Manager) Set(res *ResourceConfig) error {
+	if m.Driver == DriverSystemd {
+		return m.systemdSet(res)
+	}
+	return m.fsSet(res)
+}
+
+// Destroy 释放 Cgroup
+func (m *Manager) Destroy() error {
+	if m.Driver == DriverSystemd {
+		return m.systemdDestroy()
+	}
+	return m.fsDestroy()
+}
+
+// fsApply 通过 cgroupfs 将 PID 加入Cgroup，cgroup v2 下所有 subsystem 共用一个目录，只需要写一次 cgroup.procs
+func (m *Manager) fsApply(pid int) error {
+	if util.IsCgroup2UnifiedMode() {
+		return applyUnified(m.CgroupName, pid)
+	}
 	for _, ss := range Subsystems {
 		err := ss.Apply(m.CgroupName, pid)
 		if err != nil {
@@ -47,8 +135,8 @@ func (m *Manager) Apply(pid int) error {
 	return nil
 }
 
-// Set 设置资源限制
-func (m *Manager) Set(res *ResourceConfig) error {
+// fsSet 通过 cgroupfs 设置资源限制
+func (m *Manager) fsSet(res *ResourceConfig) error {
 	for _, ss := range Subsystems {
 		err := ss.Set(m.CgroupName, res)
 		if err != nil {
@@ -58,8 +146,11 @@ func (m *Manager) Set(res *ResourceConfig) error {
 	return nil
 }
 
-// Destroy 释放 Cgroup
-func (m *Manager) Destroy() error {
+// fsDestroy 通过 cgroupfs 释放 Cgroup
+func (m *Manager) fsDestroy() error {
+	if util.IsCgroup2UnifiedMode() {
+		return removeUnified(m.CgroupName)
+	}
 	for _, ss := range Subsystems {
 		err := ss.Remove(m.CgroupName)
 		if err != nil {
@@ -69,3 +160,77 @@ func (m *Manager) Destroy() error {
 	}
 	return nil
 }
+
+// GetStats 读取 Cgroup 的资源使用情况，某个 subsystem 读取失败时只记录日志，返回其余 subsystem 的统计，
+// 所有 subsystem 都读取失败时返回错误
+func (m *Manager) GetStats() (*Stats, error) {
+	stats := &Stats{}
+	var errs []string
+	for _, ss := range Subsystems {
+		if err := ss.GetStats(m.CgroupName, stats); err != nil {
+			logrus.Warningf("failed to get stats of cgroup %v in %v: %v", m.CgroupName, ss.Name(), err)
+			errs = append(errs, fmt.Sprintf("%v: %v", ss.Name(), err))
+		}
+	}
+	if len(errs) == len(Subsystems) {
+		return nil, fmt.Errorf("failed to get stats of cgroup %v: %v", m.CgroupName, strings.Join(errs, "; "))
+	}
+	if util.IsCgroup2UnifiedMode() {
+		stats.Pressure = getPressureStats(m.CgroupName)
+	}
+	return stats, nil
+}
+
+// Freeze 挂起或恢复 Cgroup 内的所有进程，state 为 Frozen 或 Thawed
+func (m *Manager) Freeze(state string) error {
+	return (&FreezerSubsystem{}).SetState(m.CgroupName, state)
+}
+
+// GetFreezerState 返回 Cgroup 当前的冻结状态
+func (m *Manager) GetFreezerState() (string, error) {
+	return (&FreezerSubsystem{}).GetState(m.CgroupName)
+}
+
+// controllerMounted 判断 cgroup v1 中可选的 controller（pids、blkio、freezer、devices、hugetlb）是否挂载，cgroup v2 下由 enableController 检查。
+// 没有挂载时 Apply 和 Remove 直接跳过，只有设置了需要它的限制时 Set 才会报错，因此不会影响没有设置相应限制的容器
+func controllerMounted(subsystem string) bool {
+	return util.IsCgroup2UnifiedMode() || util.IsCgroupMounted(subsystem)
+}
+
+// writeCgroupFile 将 value 写入 cgroupPath 下的控制文件 file
+func writeCgroupFile(cgroupPath, file, value string) error {
+	filePath := path.Join(cgroupPath, file)
+	if err := ioutil.WriteFile(filePath, []byte(value), 0644); err != nil {
+		logrus.Errorf("failed to write %v to %v: %v", value, filePath, err)
+		return err
+	}
+	return nil
+}
+
+// readCgroupFile 读取 cgroupPath 下的控制文件 file，并去掉首尾空白
+func readCgroupFile(cgroupPath, file string) (string, error) {
+	filePath := path.Join(cgroupPath, file)
+	body, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		logrus.Errorf("failed to read %v: %v", filePath, err)
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// readCgroupUint 读取 cgroupPath 下只包含一个整数的控制文件，"max" 表示不限制，返回 0
+func readCgroupUint(cgroupPath, file string) (uint64, error) {
+	value, err := readCgroupFile(cgroupPath, file)
+	if err != nil {
+		return 0, err
+	}
+	if value == "max" {
+		return 0, nil
+	}
+	var result uint64
+	if result, err = strconv.ParseUint(value, 10, 64); err != nil {
+		logrus.Errorf("failed to parse %v of %v: %v", value, path.Join(cgroupPath, file), err)
+		return 0, err
+	}
+	return result, nil
+}
@@ -0,0 +1,173 @@
+package cgroup
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wangao1236/my-runc/pkg/util"
+)
+
+const (
+	// DefaultCPUPeriod 是内核默认的 CFS 调度周期，单位为微秒
+	DefaultCPUPeriod uint64 = 100000
+
+	minCPUShare  = 2
+	maxCPUShare  = 262144
+	minCPUPeriod = 1000
+	maxCPUPeriod = 1000000
+	minCPUQuota  = 1000
+)
+
+var _ Subsystem = &CPUSubsystem{}
+
+type CPUSubsystem struct {
+}
+
+func (s *CPUSubsystem) Name() string {
+	return "cpu"
+}
+
+func (s *CPUSubsystem) Set(cgroupName string, res *ResourceConfig) error {
+	if len(res.CPUShare) == 0 && res.CPUQuota == 0 && res.CPUPeriod == 0 {
+		return nil
+	}
+	if err := validateCPU(res); err != nil {
+		logrus.Errorf("invalid cpu limits of %v: %v", cgroupName, err)
+		return err
+	}
+
+	cgroupPath, err := getCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("path of cgroup (%v) in (%v) is %v", cgroupName, s.Name(), cgroupPath)
+
+	if util.IsCgroup2UnifiedMode() {
+		return s.setUnified(cgroupPath, cgroupName, res)
+	}
+	if len(res.CPUShare) > 0 {
+		if err = writeCgroupFile(cgroupPath, "cpu.shares", res.CPUShare); err != nil {
+			return fmt.Errorf("failed to set cpu share of %v: %v", cgroupName, err)
+		}
+	}
+	// 先写 period 再写 quota：内核会用当前的 period 校验 quota 是否合法
+	if res.CPUPeriod != 0 {
+		period := strconv.FormatUint(res.CPUPeriod, 10)
+		if err = writeCgroupFile(cgroupPath, "cpu.cfs_period_us", period); err != nil {
+			return fmt.Errorf("failed to set cpu period of %v: %v", cgroupName, err)
+		}
+	}
+	if res.CPUQuota != 0 {
+		quota := strconv.FormatInt(res.CPUQuota, 10)
+		if err = writeCgroupFile(cgroupPath, "cpu.cfs_quota_us", quota); err != nil {
+			return fmt.Errorf("failed to set cpu quota of %v: %v", cgroupName, err)
+		}
+	}
+	return nil
+}
+
+// setUnified 设置 cgroup v2 下的 cpu 限制：cpu.shares 换算为 cpu.weight，quota 和 period 一起写入 cpu.max
+func (s *CPUSubsystem) setUnified(cgroupPath, cgroupName string, res *ResourceConfig) error {
+	if len(res.CPUShare) > 0 {
+		share, _ := strconv.ParseUint(res.CPUShare, 10, 64)
+		weight := strconv.FormatUint(sharesToWeight(share), 10)
+		if err := writeCgroupFile(cgroupPath, "cpu.weight", weight); err != nil {
+			return fmt.Errorf("failed to set cpu weight of %v: %v", cgroupName, err)
+		}
+	}
+	if res.CPUQuota != 0 || res.CPUPeriod != 0 {
+		quota := "max"
+		if res.CPUQuota > 0 {
+			quota = strconv.FormatInt(res.CPUQuota, 10)
+		}
+		if res.CPUPeriod != 0 {
+			quota += " " + strconv.FormatUint(res.CPUPeriod, 10)
+		}
+		if err := writeCgroupFile(cgroupPath, "cpu.max", quota); err != nil {
+			return fmt.Errorf("failed to set cpu max of %v: %v", cgroupName, err)
+		}
+	}
+	return nil
+}
+
+func (s *CPUSubsystem) Apply(cgroupName string, pid int) error {
+	cgroupPath, err := getCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("path of cgroup (%v) in (%v) is %v", cgroupName, s.Name(), cgroupPath)
+
+	// 将 PID 加入该 cgroup
+	if err = writeCgroupFile(cgroupPath, "cgroup.procs", strconv.Itoa(pid)); err != nil {
+		return fmt.Errorf("failed to add pid to %v: %v", cgroupName, err)
+	}
+	return nil
+}
+
+func (s *CPUSubsystem) Remove(cgroupName string) error {
+	cgroupPath, err := getCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("try to remove path of cgroup (%v) in (%v) is %v", cgroupName, s.Name(), cgroupPath)
+	return os.RemoveAll(cgroupPath)
+}
+
+func (s *CPUSubsystem) GetStats(cgroupName string, stats *Stats) error {
+	cgroupPath, err := findCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		return err
+	}
+	var cpuStat map[string]uint64
+	if cpuStat, err = readCgroupKeyValues(cgroupPath, "cpu.stat"); err != nil {
+		return err
+	}
+	stats.CPU.NrThrottled = cpuStat["nr_throttled"]
+	// cgroup v1 的 CPU 使用时间由 cpuacct 提供，v2 统一在 cpu.stat 中，且单位为微秒
+	if util.IsCgroup2UnifiedMode() {
+		stats.CPU.UsageNanos = cpuStat["usage_usec"] * 1000
+		stats.CPU.ThrottledNanos = cpuStat["throttled_usec"] * 1000
+	} else {
+		stats.CPU.ThrottledNanos = cpuStat["throttled_time"]
+	}
+	return nil
+}
+
+// validateCPU 校验 cpu 相关的限制，取值范围与内核保持一致
+func validateCPU(res *ResourceConfig) error {
+	if len(res.CPUShare) > 0 {
+		share, err := strconv.ParseUint(res.CPUShare, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid cpu share %q: %v", res.CPUShare, err)
+		}
+		if share < minCPUShare || share > maxCPUShare {
+			return fmt.Errorf("cpu share %v out of range [%v, %v]", share, minCPUShare, maxCPUShare)
+		}
+	}
+	if res.CPUPeriod != 0 && (res.CPUPeriod < minCPUPeriod || res.CPUPeriod > maxCPUPeriod) {
+		return fmt.Errorf("cpu period %v out of range [%v, %v]", res.CPUPeriod, minCPUPeriod, maxCPUPeriod)
+	}
+	if res.CPUQuota != 0 && res.CPUQuota != -1 && res.CPUQuota < minCPUQuota {
+		return fmt.Errorf("cpu quota %v is too small, the minimum is %v (or -1 for unlimited)",
+			res.CPUQuota, minCPUQuota)
+	}
+	return nil
+}
+
+// sharesToWeight 将 cgroup v1 的 cpu.shares [2, 262144] 线性映射为 cgroup v2 的 cpu.weight [1, 10000]
+func sharesToWeight(share uint64) uint64 {
+	return 1 + ((share-minCPUShare)*9999)/(maxCPUShare-minCPUShare)
+}
+
+// CPUsToQuota 将 --cpus 指定的 CPU 个数换算为一个调度周期内的 quota，period 为 0 时使用默认周期
+func CPUsToQuota(cpus float64, period uint64) (int64, uint64, error) {
+	if cpus <= 0 {
+		return 0, 0, fmt.Errorf("cpus must be positive, got %v", cpus)
+	}
+	if period == 0 {
+		period = DefaultCPUPeriod
+	}
+	return int64(cpus * float64(period)), period, nil
+}
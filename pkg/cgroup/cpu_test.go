@@ -0,0 +1,31 @@
+package cgroup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCPUsToQuota(t *testing.T) {
+	// 未指定 period 时使用默认的 100ms
+	quota, period, err := CPUsToQuota(1.5, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(150000), quota)
+	assert.Equal(t, DefaultCPUPeriod, period)
+
+	quota, period, err = CPUsToQuota(0.5, 50000)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(25000), quota)
+	assert.Equal(t, uint64(50000), period)
+
+	_, _, err = CPUsToQuota(0, 0)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestValidateCPU(t *testing.T) {
+	assert.Equal(t, nil, validateCPU(&ResourceConfig{CPUShare: "512", CPUQuota: -1, CPUPeriod: 100000}))
+	assert.NotEqual(t, nil, validateCPU(&ResourceConfig{CPUShare: "1"}))
+	assert.NotEqual(t, nil, validateCPU(&ResourceConfig{CPUShare: "abc"}))
+	assert.NotEqual(t, nil, validateCPU(&ResourceConfig{CPUPeriod: 100}))
+	assert.NotEqual(t, nil, validateCPU(&ResourceConfig{CPUQuota: 10}))
+}
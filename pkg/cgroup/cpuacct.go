@@ -0,0 +1,59 @@
+package cgroup
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wangao1236/my-runc/pkg/util"
+)
+
+var _ Subsystem = &CpuacctSubsystem{}
+
+// CpuacctSubsystem 只用于统计 cgroup v1 中的 CPU 使用时间，不做任何限制；cgroup v2 中由 cpu.stat 提供
+type CpuacctSubsystem struct {
+}
+
+func (s *CpuacctSubsystem) Name() string {
+	return "cpuacct"
+}
+
+func (s *CpuacctSubsystem) Set(cgroupName string, res *ResourceConfig) error {
+	return nil
+}
+
+func (s *CpuacctSubsystem) Apply(cgroupName string, pid int) error {
+	cgroupPath, err := getCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("path of cgroup (%v) in (%v) is %v", cgroupName, s.Name(), cgroupPath)
+
+	// 将 PID 加入该 cgroup
+	if err = writeCgroupFile(cgroupPath, "cgroup.procs", strconv.Itoa(pid)); err != nil {
+		return fmt.Errorf("failed to add pid to %v: %v", cgroupName, err)
+	}
+	return nil
+}
+
+func (s *CpuacctSubsystem) Remove(cgroupName string) error {
+	cgroupPath, err := getCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("try to remove path of cgroup (%v) in (%v) is %v", cgroupName, s.Name(), cgroupPath)
+	return os.RemoveAll(cgroupPath)
+}
+
+func (s *CpuacctSubsystem) GetStats(cgroupName string, stats *Stats) error {
+	if util.IsCgroup2UnifiedMode() {
+		return nil
+	}
+	cgroupPath, err := findCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		return err
+	}
+	stats.CPU.UsageNanos, err = readCgroupUint(cgroupPath, "cpuacct.usage")
+	return err
+}
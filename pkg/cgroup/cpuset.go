@@ -0,0 +1,219 @@
+package cgroup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wangao1236/my-runc/pkg/util"
+)
+
+const (
+	onlineCPUsPath  = "/sys/devices/system/cpu/online"
+	onlineNodesPath = "/sys/devices/system/node/online"
+
+	// maxCpusetID 是 cpu 和 NUMA 节点编号的上限（内核 NR_CPUS 的最大值为 8192）
+	maxCpusetID = 8191
+)
+
+var _ Subsystem = &CpusetSubsystem{}
+
+type CpusetSubsystem struct {
+}
+
+func (s *CpusetSubsystem) Name() string {
+	return "cpuset"
+}
+
+func (s *CpusetSubsystem) Set(cgroupName string, res *ResourceConfig) error {
+	if len(res.CPUSet) == 0 && len(res.CPUSetMems) == 0 {
+		return nil
+	}
+	if err := validateCpuset(res); err != nil {
+		logrus.Errorf("invalid cpuset limits of %v: %v", cgroupName, err)
+		return err
+	}
+
+	cgroupPath, err := s.getCgroupPath(cgroupName)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("path of cgroup (%v) in (%v) is %v", cgroupName, s.Name(), cgroupPath)
+
+	// 先写 mems 再写 cpus，二者任一为空时都会导致进程无法加入该 cgroup
+	if len(res.CPUSetMems) > 0 {
+		if err = writeCgroupFile(cgroupPath, "cpuset.mems", res.CPUSetMems); err != nil {
+			return fmt.Errorf("failed to set cpuset mems of %v: %v", cgroupName, err)
+		}
+	}
+	if len(res.CPUSet) > 0 {
+		if err = writeCgroupFile(cgroupPath, "cpuset.cpus", res.CPUSet); err != nil {
+			return fmt.Errorf("failed to set cpuset cpus of %v: %v", cgroupName, err)
+		}
+	}
+	return nil
+}
+
+func (s *CpusetSubsystem) Apply(cgroupName string, pid int) error {
+	cgroupPath, err := s.getCgroupPath(cgroupName)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("path of cgroup (%v) in (%v) is %v", cgroupName, s.Name(), cgroupPath)
+
+	// 将 PID 加入该 cgroup
+	if err = writeCgroupFile(cgroupPath, "cgroup.procs", strconv.Itoa(pid)); err != nil {
+		return fmt.Errorf("failed to add pid to %v: %v", cgroupName, err)
+	}
+	return nil
+}
+
+func (s *CpusetSubsystem) Remove(cgroupName string) error {
+	cgroupPath, err := s.getCgroupPath(cgroupName)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("try to remove path of cgroup (%v) in (%v) is %v", cgroupName, s.Name(), cgroupPath)
+	return os.RemoveAll(cgroupPath)
+}
+
+func (s *CpusetSubsystem) GetStats(cgroupName string, stats *Stats) error {
+	return nil
+}
+
+// getCgroupPath 获取 cpuset subsystem 下自定义 Cgroup 的路径，如：/sys/fs/cgroup/cpuset/my-runc-cgroup。
+// cgroup v1 中新建的 cpuset cgroup 的 cpuset.cpus 和 cpuset.mems 都是空的，此时加入进程会失败，因此需要从父 cgroup 继承；
+// cgroup v2 中为空表示使用父节点的 effective 配置，不需要处理
+func (s *CpusetSubsystem) getCgroupPath(cgroupName string) (string, error) {
+	cgroupPath, err := getCgroupPath(s.Name(), cgroupName)
+	if err != nil || util.IsCgroup2UnifiedMode() {
+		return cgroupPath, err
+	}
+	root, _ := util.FindCgroupMountPoint(s.Name())
+	if err = inheritCpuset(root, cgroupPath); err != nil {
+		logrus.Errorf("failed to inherit cpuset from parent of %v: %v", cgroupPath, err)
+		return "", err
+	}
+	return cgroupPath, nil
+}
+
+// inheritCpuset 自顶向下检查 root 到 cgroupPath 之间的每一级 cgroup，cpuset.cpus 或 cpuset.mems 为空时从父 cgroup 复制
+func inheritCpuset(root, cgroupPath string) error {
+	root = filepath.Clean(root)
+	cgroupPath = filepath.Clean(cgroupPath)
+	if cgroupPath == root {
+		return nil
+	}
+	parent := filepath.Dir(cgroupPath)
+	if err := inheritCpuset(root, parent); err != nil {
+		return err
+	}
+	for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+		current, err := readCgroupFile(cgroupPath, file)
+		if err != nil {
+			return err
+		}
+		if len(current) > 0 {
+			continue
+		}
+		var value string
+		if value, err = readCgroupFile(parent, file); err != nil {
+			return err
+		}
+		if err = writeCgroupFile(cgroupPath, file, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateCpuset 校验 cpu 列表都在线，mems 列表格式合法且对应的 NUMA 节点都在线
+func validateCpuset(res *ResourceConfig) error {
+	if len(res.CPUSet) > 0 {
+		if err := validateList(res.CPUSet, onlineCPUsPath, "cpu"); err != nil {
+			return err
+		}
+	}
+	if len(res.CPUSetMems) > 0 {
+		if err := validateList(res.CPUSetMems, onlineNodesPath, "memory node"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateList(list, onlinePath, kind string) error {
+	body, err := ioutil.ReadFile(onlinePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		logrus.Warningf("%v does not exist, skip checking whether %v list %q is online", onlinePath, kind, list)
+		if _, err = parseList(list, maxCpusetID); err != nil {
+			return fmt.Errorf("invalid %v list %q: %v", kind, list, err)
+		}
+		return nil
+	}
+	onlineList := strings.TrimSpace(string(body))
+	online, err := parseList(onlineList, maxCpusetID)
+	if err != nil {
+		return fmt.Errorf("invalid content of %v: %v", onlinePath, err)
+	}
+	// 请求的列表不能超过在线的最大编号，避免展开过大的范围
+	maxOnline := 0
+	for id := range online {
+		if id > maxOnline {
+			maxOnline = id
+		}
+	}
+	requested, err := parseList(list, maxOnline)
+	if err != nil {
+		return fmt.Errorf("invalid %v list %q (online: %v): %v", kind, list, onlineList, err)
+	}
+	var offline []int
+	for id := range requested {
+		if !online[id] {
+			offline = append(offline, id)
+		}
+	}
+	if len(offline) > 0 {
+		sort.Ints(offline)
+		return fmt.Errorf("%v %v is not online, online: %v", kind, offline[0], onlineList)
+	}
+	return nil
+}
+
+// parseList 解析 cpuset 格式的列表，如 "0-3,5,7-8"，编号大于 max 时在展开范围之前返回错误
+func parseList(list string, max int) (map[int]bool, error) {
+	result := make(map[int]bool)
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			return nil, fmt.Errorf("empty element")
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		start, err := strconv.Atoi(bounds[0])
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid element %q", part)
+		}
+		end := start
+		if len(bounds) == 2 {
+			end, err = strconv.Atoi(bounds[1])
+			if err != nil || end < start {
+				return nil, fmt.Errorf("invalid range %q", part)
+			}
+		}
+		if end > max {
+			return nil, fmt.Errorf("element %q exceeds the maximum %v", part, max)
+		}
+		for i := start; i <= end; i++ {
+			result[i] = true
+		}
+	}
+	return result, nil
+}
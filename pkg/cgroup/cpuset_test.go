@@ -0,0 +1,21 @@
+package cgroup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseList(t *testing.T) {
+	list, err := parseList("0-2,5,7-8", maxCpusetID)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[int]bool{0: true, 1: true, 2: true, 5: true, 7: true, 8: true}, list)
+
+	for _, invalid := range []string{"", "a", "3-1", "1,,2", "-1", "0-1000000000", "8192"} {
+		_, err = parseList(invalid, maxCpusetID)
+		assert.NotEqual(t, nil, err, invalid)
+	}
+
+	_, err = parseList("0-4", 3)
+	assert.NotEqual(t, nil, err)
+}
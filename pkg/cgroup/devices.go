@@ -0,0 +1,204 @@
+package cgroup
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wangao1236/my-runc/pkg/util"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	DeviceTypeAll   = "a"
+	DeviceTypeChar  = "c"
+	DeviceTypeBlock = "b"
+
+	// DeviceWildcard 表示匹配任意的主设备号或次设备号
+	DeviceWildcard int64 = -1
+)
+
+var _ Subsystem = &DevicesSubsystem{}
+
+// Device 表示容器可以访问的一个设备，Permissions 由 r（读）、w（写）、m（mknod）组成
+type Device struct {
+	// Path 是设备在容器内的路径，为空时只作为访问规则，不会创建设备文件
+	Path        string      `json:"path,omitempty"`
+	Type        string      `json:"type"`
+	Major       int64       `json:"major"`
+	Minor       int64       `json:"minor"`
+	Permissions string      `json:"permissions"`
+	FileMode    os.FileMode `json:"fileMode,omitempty"`
+}
+
+// DefaultDevices 返回每个容器默认都可以访问的设备
+func DefaultDevices() []*Device {
+	return []*Device{
+		{Path: "/dev/null", Type: DeviceTypeChar, Major: 1, Minor: 3, Permissions: "rwm", FileMode: 0666},
+		{Path: "/dev/zero", Type: DeviceTypeChar, Major: 1, Minor: 5, Permissions: "rwm", FileMode: 0666},
+		{Path: "/dev/full", Type: DeviceTypeChar, Major: 1, Minor: 7, Permissions: "rwm", FileMode: 0666},
+		{Path: "/dev/random", Type: DeviceTypeChar, Major: 1, Minor: 8, Permissions: "rwm", FileMode: 0666},
+		{Path: "/dev/urandom", Type: DeviceTypeChar, Major: 1, Minor: 9, Permissions: "rwm", FileMode: 0666},
+		{Path: "/dev/tty", Type: DeviceTypeChar, Major: 5, Minor: 0, Permissions: "rwm", FileMode: 0666},
+		// /dev/pts/* 和 /dev/ptmx 由容器内挂载的 devpts 提供，这里只允许访问
+		{Type: DeviceTypeChar, Major: 136, Minor: DeviceWildcard, Permissions: "rwm"},
+		{Type: DeviceTypeChar, Major: 5, Minor: 2, Permissions: "rwm"},
+	}
+}
+
+// NewDevice 根据宿主机上的设备文件生成容器内路径为 containerPath 的设备
+func NewDevice(hostPath, containerPath, permissions string) (*Device, error) {
+	if err := validatePermissions(permissions); err != nil {
+		return nil, err
+	}
+	var st unix.Stat_t
+	if err := unix.Stat(hostPath, &st); err != nil {
+		logrus.Errorf("failed to stat device %v: %v", hostPath, err)
+		return nil, err
+	}
+	device := &Device{
+		Path:        containerPath,
+		Major:       int64(unix.Major(st.Rdev)),
+		Minor:       int64(unix.Minor(st.Rdev)),
+		Permissions: permissions,
+		FileMode:    os.FileMode(st.Mode &^ unix.S_IFMT),
+	}
+	switch st.Mode & unix.S_IFMT {
+	case unix.S_IFCHR:
+		device.Type = DeviceTypeChar
+	case unix.S_IFBLK:
+		device.Type = DeviceTypeBlock
+	default:
+		return nil, fmt.Errorf("%v is not a device", hostPath)
+	}
+	return device, nil
+}
+
+// Rule 返回写入 devices.allow 的格式，如：c 1:3 rwm、c 136:* rwm
+func (d *Device) Rule() string {
+	if d.Type == DeviceTypeAll {
+		return "a *:* " + d.Permissions
+	}
+	return fmt.Sprintf("%v %v:%v %v", d.Type, deviceNumber(d.Major), deviceNumber(d.Minor), d.Permissions)
+}
+
+func deviceNumber(number int64) string {
+	if number == DeviceWildcard {
+		return "*"
+	}
+	return strconv.FormatInt(number, 10)
+}
+
+func validatePermissions(permissions string) error {
+	if len(permissions) == 0 || len(permissions) > 3 {
+		return fmt.Errorf("invalid device permissions %q", permissions)
+	}
+	for _, c := range permissions {
+		if !strings.ContainsRune("rwm", c) || strings.Count(permissions, string(c)) > 1 {
+			return fmt.Errorf("invalid device permissions %q, only r, w and m are allowed", permissions)
+		}
+	}
+	return nil
+}
+
+// DevicesSubsystem 限制容器可以访问的设备：cgroup v1 下使用 devices controller 的白名单，
+// cgroup v2 下没有对应的控制文件，需要在 cgroup 上挂载 BPF_PROG_TYPE_CGROUP_DEVICE 类型的 eBPF 程序
+type DevicesSubsystem struct {
+}
+
+func (s *DevicesSubsystem) Name() string {
+	return "devices"
+}
+
+func (s *DevicesSubsystem) Set(cgroupName string, res *ResourceConfig) error {
+	if len(res.Devices) == 0 {
+		return nil
+	}
+	cgroupPath, err := getCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("path of cgroup (%v) in (%v) is %v", cgroupName, s.Name(), cgroupPath)
+
+	if util.IsCgroup2UnifiedMode() {
+		if err = attachDeviceFilter(cgroupPath, res.Devices); err != nil {
+			return fmt.Errorf("failed to attach device filter to %v: %v", cgroupName, err)
+		}
+		return nil
+	}
+
+	var rules []string
+	for _, device := range res.Devices {
+		rules = append(rules, device.Rule())
+	}
+	// 修改白名单需要先拒绝所有设备，容器运行时会短暂地无法访问设备，因此白名单没有变化时跳过
+	var current string
+	if current, err = readCgroupFile(cgroupPath, "devices.list"); err != nil {
+		return err
+	}
+	if sameRules(strings.Split(current, "\n"), rules) {
+		return nil
+	}
+	if err = writeCgroupFile(cgroupPath, "devices.deny", "a"); err != nil {
+		return fmt.Errorf("failed to deny all devices of %v: %v", cgroupName, err)
+	}
+	for _, rule := range rules {
+		if err = writeCgroupFile(cgroupPath, "devices.allow", rule); err != nil {
+			return fmt.Errorf("failed to allow device %v of %v: %v", rule, cgroupName, err)
+		}
+	}
+	return nil
+}
+
+func (s *DevicesSubsystem) Apply(cgroupName string, pid int) error {
+	if !controllerMounted(s.Name()) {
+		logrus.Infof("cgroup controller %v is not mounted, skip it", s.Name())
+		return nil
+	}
+	cgroupPath, err := getCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("path of cgroup (%v) in (%v) is %v", cgroupName, s.Name(), cgroupPath)
+
+	// 将 PID 加入该 cgroup
+	if err = writeCgroupFile(cgroupPath, "cgroup.procs", strconv.Itoa(pid)); err != nil {
+		return fmt.Errorf("failed to add pid to %v: %v", cgroupName, err)
+	}
+	return nil
+}
+
+func (s *DevicesSubsystem) Remove(cgroupName string) error {
+	if !controllerMounted(s.Name()) {
+		return nil
+	}
+	cgroupPath, err := getCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("try to remove path of cgroup (%v) in (%v) is %v", cgroupName, s.Name(), cgroupPath)
+	return os.RemoveAll(cgroupPath)
+}
+
+func (s *DevicesSubsystem) GetStats(cgroupName string, stats *Stats) error {
+	return nil
+}
+
+func sameRules(current, expected []string) bool {
+	if len(current) != len(expected) {
+		return false
+	}
+	a := append([]string{}, current...)
+	b := append([]string{}, expected...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
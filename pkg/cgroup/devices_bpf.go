@@ -0,0 +1,267 @@
+package cgroup
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"unsafe"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// bpfInsn 对应内核中的 struct bpf_insn，Regs 的低 4 位为目的寄存器，高 4 位为源寄存器
+type bpfInsn struct {
+	Code uint8
+	Regs uint8
+	Off  int16
+	Imm  int32
+}
+
+// 以下结构体对应 union bpf_attr 中各个命令使用的部分
+type bpfProgLoadAttr struct {
+	ProgType    uint32
+	InsnCnt     uint32
+	Insns       uint64
+	License     uint64
+	LogLevel    uint32
+	LogSize     uint32
+	LogBuf      uint64
+	KernVersion uint32
+	ProgFlags   uint32
+}
+
+type bpfProgAttachAttr struct {
+	TargetFd    uint32
+	AttachBpfFd uint32
+	AttachType  uint32
+	AttachFlags uint32
+}
+
+type bpfProgQueryAttr struct {
+	TargetFd    uint32
+	AttachType  uint32
+	QueryFlags  uint32
+	AttachFlags uint32
+	ProgIds     uint64
+	ProgCnt     uint32
+}
+
+type bpfGetFdByIDAttr struct {
+	ID        uint32
+	NextID    uint32
+	OpenFlags uint32
+}
+
+const (
+	bpfLogSize      = 64 * 1024
+	maxQueryProgs   = 64
+	deviceAccessAll = unix.BPF_DEVCG_ACC_READ | unix.BPF_DEVCG_ACC_WRITE | unix.BPF_DEVCG_ACC_MKNOD
+)
+
+func bpfLdxW(dst, src uint8, off int16) bpfInsn {
+	return bpfInsn{Code: unix.BPF_LDX | unix.BPF_W | unix.BPF_MEM, Regs: src<<4 | dst, Off: off}
+}
+
+func bpfAlu64Imm(op, dst uint8, imm int32) bpfInsn {
+	return bpfInsn{Code: unix.BPF_ALU64 | op | unix.BPF_K, Regs: dst, Imm: imm}
+}
+
+func bpfMov64Reg(dst, src uint8) bpfInsn {
+	return bpfInsn{Code: unix.BPF_ALU64 | unix.BPF_MOV | unix.BPF_X, Regs: src<<4 | dst}
+}
+
+func bpfJneImm(dst uint8, imm int32, off int16) bpfInsn {
+	return bpfInsn{Code: unix.BPF_JMP | unix.BPF_JNE | unix.BPF_K, Regs: dst, Off: off, Imm: imm}
+}
+
+func bpfExit() bpfInsn {
+	return bpfInsn{Code: unix.BPF_JMP | unix.BPF_EXIT}
+}
+
+// buildDeviceFilter 生成 BPF_PROG_TYPE_CGROUP_DEVICE 类型的 eBPF 程序。程序的参数为 struct bpf_cgroup_dev_ctx：
+// access_type 的低 16 位为设备类型，高 16 位为访问类型，随后是主设备号和次设备号。
+// 程序依次匹配每一条规则，匹配成功时返回 1 表示允许访问，所有规则都不匹配时返回 0 表示拒绝
+func buildDeviceFilter(devices []*Device) ([]bpfInsn, error) {
+	insns := []bpfInsn{
+		// r2 = 设备类型，r3 = 访问类型，r4 = 主设备号，r5 = 次设备号
+		bpfLdxW(2, 1, 0),
+		bpfAlu64Imm(unix.BPF_AND, 2, 0xffff),
+		bpfLdxW(3, 1, 0),
+		bpfAlu64Imm(unix.BPF_RSH, 3, 16),
+		bpfLdxW(4, 1, 4),
+		bpfLdxW(5, 1, 8),
+	}
+	for _, device := range devices {
+		block, err := deviceFilterBlock(device)
+		if err != nil {
+			return nil, err
+		}
+		insns = append(insns, block...)
+	}
+	// 默认拒绝
+	return append(insns, bpfAlu64Imm(unix.BPF_MOV, 0, 0), bpfExit()), nil
+}
+
+// deviceFilterBlock 生成匹配一条规则的指令，任一条件不满足时跳转到下一条规则
+func deviceFilterBlock(device *Device) ([]bpfInsn, error) {
+	if err := validatePermissions(device.Permissions); err != nil {
+		return nil, err
+	}
+	var access int32
+	for _, c := range device.Permissions {
+		switch c {
+		case 'r':
+			access |= unix.BPF_DEVCG_ACC_READ
+		case 'w':
+			access |= unix.BPF_DEVCG_ACC_WRITE
+		case 'm':
+			access |= unix.BPF_DEVCG_ACC_MKNOD
+		}
+	}
+
+	// 跳转的偏移量需要在生成整个块之后计算，这里先记录下需要跳转的指令
+	var block []bpfInsn
+	var jumps []int
+	switch device.Type {
+	case DeviceTypeAll:
+	case DeviceTypeChar:
+		jumps = append(jumps, len(block))
+		block = append(block, bpfJneImm(2, unix.BPF_DEVCG_DEV_CHAR, 0))
+	case DeviceTypeBlock:
+		jumps = append(jumps, len(block))
+		block = append(block, bpfJneImm(2, unix.BPF_DEVCG_DEV_BLOCK, 0))
+	default:
+		return nil, fmt.Errorf("invalid device type %q", device.Type)
+	}
+	if access != deviceAccessAll {
+		// 请求的访问类型中有不被允许的部分时不匹配
+		block = append(block, bpfMov64Reg(1, 3), bpfAlu64Imm(unix.BPF_AND, 1, ^access&deviceAccessAll))
+		jumps = append(jumps, len(block))
+		block = append(block, bpfJneImm(1, 0, 0))
+	}
+	if device.Type != DeviceTypeAll && device.Major != DeviceWildcard {
+		jumps = append(jumps, len(block))
+		block = append(block, bpfJneImm(4, int32(device.Major), 0))
+	}
+	if device.Type != DeviceTypeAll && device.Minor != DeviceWildcard {
+		jumps = append(jumps, len(block))
+		block = append(block, bpfJneImm(5, int32(device.Minor), 0))
+	}
+	block = append(block, bpfAlu64Imm(unix.BPF_MOV, 0, 1), bpfExit())
+	for _, i := range jumps {
+		block[i].Off = int16(len(block) - i - 1)
+	}
+	return block, nil
+}
+
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// loadDeviceFilter 将程序加载到内核中，返回程序的 fd，加载失败时返回 verifier 的日志
+func loadDeviceFilter(insns []bpfInsn) (int, error) {
+	license := []byte("Apache\x00")
+	logBuf := make([]byte, bpfLogSize)
+	attr := bpfProgLoadAttr{
+		ProgType: unix.BPF_PROG_TYPE_CGROUP_DEVICE,
+		InsnCnt:  uint32(len(insns)),
+		Insns:    uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		License:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+		LogLevel: 1,
+		LogSize:  uint32(len(logBuf)),
+		LogBuf:   uint64(uintptr(unsafe.Pointer(&logBuf[0]))),
+	}
+	fd, err := bpf(unix.BPF_PROG_LOAD, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	// attr 中只保存了地址，需要保证这些内存在系统调用结束前不被回收
+	runtime.KeepAlive(insns)
+	runtime.KeepAlive(license)
+	if err != nil {
+		return -1, fmt.Errorf("failed to load device filter: %v, verifier log: %v",
+			err, strings.TrimRight(string(logBuf), "\x00"))
+	}
+	return fd, nil
+}
+
+// queryDeviceFilters 返回 cgroup 上已经挂载的设备过滤程序的 ID
+func queryDeviceFilters(dirFd int) ([]uint32, error) {
+	ids := make([]uint32, maxQueryProgs)
+	attr := bpfProgQueryAttr{
+		TargetFd:   uint32(dirFd),
+		AttachType: unix.BPF_CGROUP_DEVICE,
+		ProgIds:    uint64(uintptr(unsafe.Pointer(&ids[0]))),
+		ProgCnt:    uint32(len(ids)),
+	}
+	if _, err := bpf(unix.BPF_PROG_QUERY, unsafe.Pointer(&attr), unsafe.Sizeof(attr)); err != nil {
+		return nil, fmt.Errorf("failed to query device filters: %v", err)
+	}
+	return ids[:attr.ProgCnt], nil
+}
+
+// attachDeviceFilter 将根据 devices 生成的程序挂载到 cgroupPath 上，并卸载之前挂载的程序。
+// 使用 BPF_F_ALLOW_MULTI 先挂载新程序再卸载旧程序，切换的过程中两个程序同时生效，不会出现短暂允许所有设备的情况
+func attachDeviceFilter(cgroupPath string, devices []*Device) error {
+	insns, err := buildDeviceFilter(devices)
+	if err != nil {
+		return err
+	}
+	var progFd int
+	if progFd, err = loadDeviceFilter(insns); err != nil {
+		return err
+	}
+	defer func() {
+		_ = unix.Close(progFd)
+	}()
+
+	var dirFd int
+	if dirFd, err = unix.Open(cgroupPath, unix.O_DIRECTORY|unix.O_RDONLY|unix.O_CLOEXEC, 0); err != nil {
+		logrus.Errorf("failed to open %v: %v", cgroupPath, err)
+		return err
+	}
+	defer func() {
+		_ = unix.Close(dirFd)
+	}()
+
+	var oldIDs []uint32
+	if oldIDs, err = queryDeviceFilters(dirFd); err != nil {
+		return err
+	}
+	attr := bpfProgAttachAttr{
+		TargetFd:    uint32(dirFd),
+		AttachBpfFd: uint32(progFd),
+		AttachType:  unix.BPF_CGROUP_DEVICE,
+		AttachFlags: unix.BPF_F_ALLOW_MULTI,
+	}
+	if _, err = bpf(unix.BPF_PROG_ATTACH, unsafe.Pointer(&attr), unsafe.Sizeof(attr)); err != nil {
+		return fmt.Errorf("failed to attach device filter: %v", err)
+	}
+
+	for _, id := range oldIDs {
+		if err = detachDeviceFilter(dirFd, id); err != nil {
+			logrus.Warningf("failed to detach old device filter %v from %v: %v", id, cgroupPath, err)
+		}
+	}
+	return nil
+}
+
+func detachDeviceFilter(dirFd int, id uint32) error {
+	getAttr := bpfGetFdByIDAttr{ID: id}
+	fd, err := bpf(unix.BPF_PROG_GET_FD_BY_ID, unsafe.Pointer(&getAttr), unsafe.Sizeof(getAttr))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = unix.Close(fd)
+	}()
+	attr := bpfProgAttachAttr{
+		TargetFd:    uint32(dirFd),
+		AttachBpfFd: uint32(fd),
+		AttachType:  unix.BPF_CGROUP_DEVICE,
+	}
+	_, err = bpf(unix.BPF_PROG_DETACH, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	return err
+}
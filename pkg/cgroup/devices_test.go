@@ -0,0 +1,41 @@
+package cgroup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestDeviceRule(t *testing.T) {
+	assert.Equal(t, "c 1:3 rwm", (&Device{Type: DeviceTypeChar, Major: 1, Minor: 3, Permissions: "rwm"}).Rule())
+	assert.Equal(t, "c 136:* rw", (&Device{Type: DeviceTypeChar, Major: 136, Minor: DeviceWildcard,
+		Permissions: "rw"}).Rule())
+	assert.Equal(t, "a *:* m", (&Device{Type: DeviceTypeAll, Permissions: "m"}).Rule())
+}
+
+func TestValidatePermissions(t *testing.T) {
+	for _, valid := range []string{"r", "rw", "mwr"} {
+		assert.Equal(t, nil, validatePermissions(valid), valid)
+	}
+	for _, invalid := range []string{"", "rr", "rwx", "rwmr"} {
+		assert.NotEqual(t, nil, validatePermissions(invalid), invalid)
+	}
+}
+
+func TestDeviceFilterBlock(t *testing.T) {
+	// 类型、访问类型、主设备号、次设备号各一个条件，所有跳转都应该落在块的末尾
+	block, err := deviceFilterBlock(&Device{Type: DeviceTypeChar, Major: 1, Minor: 11, Permissions: "r"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 8, len(block))
+	for i, insn := range block {
+		if insn.Code == unix.BPF_JMP|unix.BPF_JNE|unix.BPF_K {
+			assert.Equal(t, len(block), i+1+int(insn.Off))
+		}
+	}
+
+	// 允许所有设备的所有访问时只需要返回 1
+	block, err = deviceFilterBlock(&Device{Type: DeviceTypeAll, Permissions: "rwm"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(block))
+}
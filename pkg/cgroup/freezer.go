@@ -0,0 +1,128 @@
+package cgroup
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wangao1236/my-runc/pkg/util"
+)
+
+const (
+	// Frozen 和 Thawed 是 cgroup v1 freezer.state 中的取值，冻结过程中还会出现中间状态 FREEZING
+	Frozen = "FROZEN"
+	Thawed = "THAWED"
+
+	freezeTimeout  = 5 * time.Second
+	freezeInterval = 10 * time.Millisecond
+)
+
+var _ Subsystem = &FreezerSubsystem{}
+
+// FreezerSubsystem 用于挂起和恢复 cgroup 内的所有进程：cgroup v1 下使用 freezer.state，
+// cgroup v2 下 freezer 不是一个 controller，每个非根 cgroup 都有 cgroup.freeze
+type FreezerSubsystem struct {
+}
+
+func (s *FreezerSubsystem) Name() string {
+	return "freezer"
+}
+
+func (s *FreezerSubsystem) Set(cgroupName string, res *ResourceConfig) error {
+	return nil
+}
+
+func (s *FreezerSubsystem) Apply(cgroupName string, pid int) error {
+	if !controllerMounted(s.Name()) {
+		logrus.Infof("cgroup controller %v is not mounted, skip it", s.Name())
+		return nil
+	}
+	cgroupPath, err := getCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("path of cgroup (%v) in (%v) is %v", cgroupName, s.Name(), cgroupPath)
+
+	// 将 PID 加入该 cgroup
+	if err = writeCgroupFile(cgroupPath, "cgroup.procs", strconv.Itoa(pid)); err != nil {
+		return fmt.Errorf("failed to add pid to %v: %v", cgroupName, err)
+	}
+	return nil
+}
+
+func (s *FreezerSubsystem) Remove(cgroupName string) error {
+	if !controllerMounted(s.Name()) {
+		return nil
+	}
+	cgroupPath, err := getCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("try to remove path of cgroup (%v) in (%v) is %v", cgroupName, s.Name(), cgroupPath)
+	return os.RemoveAll(cgroupPath)
+}
+
+func (s *FreezerSubsystem) GetStats(cgroupName string, stats *Stats) error {
+	return nil
+}
+
+// SetState 将 cgroup 切换为 Frozen 或 Thawed，并等待内核完成切换
+func (s *FreezerSubsystem) SetState(cgroupName, state string) error {
+	if state != Frozen && state != Thawed {
+		return fmt.Errorf("invalid freezer state %v", state)
+	}
+	cgroupPath, err := findCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		logrus.Errorf("failed to find cgroup %v in %v: %v", cgroupName, s.Name(), err)
+		return err
+	}
+
+	file, value := "freezer.state", state
+	if util.IsCgroup2UnifiedMode() {
+		file, value = "cgroup.freeze", "0"
+		if state == Frozen {
+			value = "1"
+		}
+	}
+	if err = writeCgroupFile(cgroupPath, file, value); err != nil {
+		return fmt.Errorf("failed to set freezer state of %v to %v: %v", cgroupName, state, err)
+	}
+
+	// 冻结是异步的，进程可能处于不可中断的睡眠中，需要轮询直到所有进程都被冻结
+	var current string
+	for deadline := time.Now().Add(freezeTimeout); time.Now().Before(deadline); time.Sleep(freezeInterval) {
+		if current, err = s.getState(cgroupPath); err != nil {
+			return err
+		}
+		if current == state {
+			return nil
+		}
+	}
+	return fmt.Errorf("timeout waiting for freezer state of %v to be %v, current is %v", cgroupName, state, current)
+}
+
+// GetState 返回 cgroup 当前的冻结状态
+func (s *FreezerSubsystem) GetState(cgroupName string) (string, error) {
+	cgroupPath, err := findCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		return "", err
+	}
+	return s.getState(cgroupPath)
+}
+
+// getState 读取冻结状态：cgroup v1 读取 freezer.state，cgroup v2 读取 cgroup.events 中的 frozen 字段
+func (s *FreezerSubsystem) getState(cgroupPath string) (string, error) {
+	if !util.IsCgroup2UnifiedMode() {
+		return readCgroupFile(cgroupPath, "freezer.state")
+	}
+	events, err := readCgroupKeyValues(cgroupPath, "cgroup.events")
+	if err != nil {
+		return "", err
+	}
+	if events["frozen"] == 1 {
+		return Frozen, nil
+	}
+	return Thawed, nil
+}
@@ -0,0 +1,203 @@
+package cgroup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wangao1236/my-runc/pkg/util"
+)
+
+const hugePagesDir = "/sys/kernel/mm/hugepages"
+
+var _ Subsystem = &HugetlbSubsystem{}
+
+// HugepageLimit 表示某一种大小的大页的使用上限，PageSize 与 cgroup 控制文件中的格式一致，如 2MB、1GB
+type HugepageLimit struct {
+	PageSize string `json:"pageSize"`
+	Limit    uint64 `json:"limit"`
+}
+
+// NewHugepageLimit 校验主机是否支持 pageSize 大小的大页，并将其转换为 cgroup 控制文件中的格式，
+// pageSize 支持 2MB、2M、2048kB 等写法
+func NewHugepageLimit(pageSize string, limit uint64) (*HugepageLimit, error) {
+	size, err := util.ParseBytes(pageSize)
+	if err != nil || size <= 0 {
+		return nil, fmt.Errorf("invalid hugepage size %q", pageSize)
+	}
+	name := hugePageSizeName(uint64(size) >> 10)
+	sizes, err := GetHugePageSizes()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sizes {
+		if s == name {
+			return &HugepageLimit{PageSize: name, Limit: limit}, nil
+		}
+	}
+	return nil, fmt.Errorf("hugepage size %v is not supported by the host, supported: %v", name, sizes)
+}
+
+// GetHugePageSizes 从 /sys/kernel/mm/hugepages 中读取主机支持的大页大小，目录名的格式为 hugepages-2048kB，
+// 内核不支持大页时该目录不存在，返回空列表
+func GetHugePageSizes() ([]string, error) {
+	files, err := ioutil.ReadDir(hugePagesDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		logrus.Errorf("failed to read directory (%v): %v", hugePagesDir, err)
+		return nil, err
+	}
+	var sizes []string
+	for _, file := range files {
+		size := strings.TrimSuffix(strings.TrimPrefix(file.Name(), "hugepages-"), "kB")
+		kb, parseErr := strconv.ParseUint(size, 10, 64)
+		if parseErr != nil {
+			logrus.Warningf("invalid hugepage directory %v in %v", file.Name(), hugePagesDir)
+			continue
+		}
+		sizes = append(sizes, hugePageSizeName(kb))
+	}
+	return sizes, nil
+}
+
+// hugePageSizeName 将以 KB 为单位的大页大小转换为内核中 hugetlb 控制文件使用的名称，如 2048 -> 2MB
+func hugePageSizeName(kb uint64) string {
+	switch {
+	case kb >= 1<<20 && kb%(1<<20) == 0:
+		return fmt.Sprintf("%vGB", kb>>20)
+	case kb >= 1<<10 && kb%(1<<10) == 0:
+		return fmt.Sprintf("%vMB", kb>>10)
+	default:
+		return fmt.Sprintf("%vKB", kb)
+	}
+}
+
+// HugetlbSubsystem 限制大页的使用量，cgroup v1 中使用 hugetlb.<size>.limit_in_bytes，v2 中使用 hugetlb.<size>.max。
+// 很多主机默认不挂载 hugetlb，因此没有设置大页限制时，不挂载也不会影响容器的创建
+type HugetlbSubsystem struct {
+}
+
+func (s *HugetlbSubsystem) Name() string {
+	return "hugetlb"
+}
+
+func (s *HugetlbSubsystem) Set(cgroupName string, res *ResourceConfig) error {
+	if len(res.HugetlbLimit) == 0 {
+		return nil
+	}
+	cgroupPath, err := getCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("path of cgroup (%v) in (%v) is %v", cgroupName, s.Name(), cgroupPath)
+
+	suffix := "limit_in_bytes"
+	if util.IsCgroup2UnifiedMode() {
+		suffix = "max"
+	}
+	for _, limit := range res.HugetlbLimit {
+		file := fmt.Sprintf("hugetlb.%v.%v", limit.PageSize, suffix)
+		if err = writeCgroupFile(cgroupPath, file, strconv.FormatUint(limit.Limit, 10)); err != nil {
+			return fmt.Errorf("failed to set hugetlb limit of %v for %v: %v", limit.PageSize, cgroupName, err)
+		}
+	}
+	return nil
+}
+
+func (s *HugetlbSubsystem) Apply(cgroupName string, pid int) error {
+	if !controllerMounted(s.Name()) {
+		logrus.Infof("cgroup controller %v is not mounted, skip it", s.Name())
+		return nil
+	}
+	cgroupPath, err := getCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("path of cgroup (%v) in (%v) is %v", cgroupName, s.Name(), cgroupPath)
+
+	// 将 PID 加入该 cgroup
+	if err = writeCgroupFile(cgroupPath, "cgroup.procs", strconv.Itoa(pid)); err != nil {
+		return fmt.Errorf("failed to add pid to %v: %v", cgroupName, err)
+	}
+	return nil
+}
+
+func (s *HugetlbSubsystem) Remove(cgroupName string) error {
+	if !controllerMounted(s.Name()) {
+		return nil
+	}
+	cgroupPath, err := getCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("try to remove path of cgroup (%v) in (%v) is %v", cgroupName, s.Name(), cgroupPath)
+	return os.RemoveAll(cgroupPath)
+}
+
+func (s *HugetlbSubsystem) GetStats(cgroupName string, stats *Stats) error {
+	if !controllerMounted(s.Name()) {
+		return nil
+	}
+	cgroupPath, err := findCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		// cgroup v2 下主机不支持 hugetlb 时没有对应的控制文件
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var sizes []string
+	if sizes, err = GetHugePageSizes(); err != nil || len(sizes) == 0 {
+		return err
+	}
+	usageFile := "usage_in_bytes"
+	if util.IsCgroup2UnifiedMode() {
+		// cgroup v2 下父节点没有启用 hugetlb controller 时（applyUnified 只会警告）没有对应的控制文件
+		var controllers string
+		if controllers, err = readCgroupFile(cgroupPath, "cgroup.controllers"); err != nil {
+			return err
+		}
+		if !containsField(controllers, unifiedController(s.Name())) {
+			return nil
+		}
+		usageFile = "current"
+	}
+	stats.Hugetlb = make(map[string]HugetlbStats)
+	for _, size := range sizes {
+		prefix := "hugetlb." + size + "."
+		// 内核的 hugetlb controller 可能不支持主机上的某些大页大小
+		if _, err = os.Stat(path.Join(cgroupPath, prefix+usageFile)); os.IsNotExist(err) {
+			logrus.Debugf("hugepage size %v is not supported by cgroup %v", size, cgroupPath)
+			continue
+		}
+		var hugetlbStats HugetlbStats
+		if hugetlbStats.Usage, err = readCgroupUint(cgroupPath, prefix+usageFile); err != nil {
+			return err
+		}
+		// cgroup v2 没有历史最大用量，超出上限的次数在 hugetlb.<size>.events 中，较早的内核中没有该文件
+		if util.IsCgroup2UnifiedMode() {
+			if _, err = os.Stat(path.Join(cgroupPath, prefix+"events")); err == nil {
+				var events map[string]uint64
+				if events, err = readCgroupKeyValues(cgroupPath, prefix+"events"); err != nil {
+					return err
+				}
+				hugetlbStats.Failcnt = events["max"]
+			}
+		} else {
+			if hugetlbStats.MaxUsage, err = readCgroupUint(cgroupPath, prefix+"max_usage_in_bytes"); err != nil {
+				return err
+			}
+			if hugetlbStats.Failcnt, err = readCgroupUint(cgroupPath, prefix+"failcnt"); err != nil {
+				return err
+			}
+		}
+		stats.Hugetlb[size] = hugetlbStats
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+package cgroup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHugePageSizeName(t *testing.T) {
+	assert.Equal(t, "64KB", hugePageSizeName(64))
+	assert.Equal(t, "2MB", hugePageSizeName(2048))
+	assert.Equal(t, "32MB", hugePageSizeName(32768))
+	assert.Equal(t, "1GB", hugePageSizeName(1048576))
+	assert.Equal(t, "16GB", hugePageSizeName(16777216))
+}
@@ -21,26 +21,108 @@ func (s *MemorySubsystem) Name() string {
 }
 
 func (s *MemorySubsystem) Set(cgroupName string, res *ResourceConfig) error {
+	if len(res.MemoryLimit) == 0 && len(res.MemorySwap) == 0 && !res.OOMKillDisable {
+		return nil
+	}
+	if err := validateMemory(res); err != nil {
+		logrus.Errorf("invalid memory limits of %v: %v", cgroupName, err)
+		return err
+	}
 	// 获取自定义Cgroup的路径，没有则创建，如：/sys/fs/cgroup/memory/mydocker-cgroup
-	cgroupPath, err := util.GetCgroupPath(s.Name(), cgroupName)
+	cgroupPath, err := getCgroupPath(s.Name(), cgroupName)
 	if err != nil {
 		logrus.Infof("failed to get path of cgroup (%v) in (%v): %v", cgroupName, s.Name(), err)
 		return err
 	}
 	logrus.Infof("path of cgroup (%v) in (%v) is %v", cgroupName, s.Name(), cgroupPath)
 
-	// 将资源限制写入
-	limitFilePath := path.Join(cgroupPath, "memory.limit_in_bytes")
-	if err = ioutil.WriteFile(limitFilePath, []byte(res.MemoryLimit), 0644); err != nil {
-		logrus.Errorf("failed to set memory limit of %v: %v", cgroupName, err)
-		return fmt.Errorf("failed to set memory limit of %v: %v", cgroupName, err)
+	if util.IsCgroup2UnifiedMode() {
+		return s.setUnified(cgroupPath, cgroupName, res)
+	}
+	// 将资源限制写入，内核只能识别 100m 这样的整数加单位，因此统一转换为字节数后再写入
+	if err = s.setLimits(cgroupPath, cgroupName, res); err != nil {
+		return err
+	}
+	if res.OOMKillDisable {
+		if err = writeCgroupFile(cgroupPath, "memory.oom_control", "1"); err != nil {
+			return fmt.Errorf("failed to disable oom killer of %v: %v", cgroupName, err)
+		}
+	}
+	return nil
+}
+
+// setLimits 设置 cgroup v1 的内存上限以及内存与 swap 的总上限。
+// 内核要求 memory.limit_in_bytes 始终不大于 memory.memsw.limit_in_bytes，因此调大总上限时需要先写 memsw
+func (s *MemorySubsystem) setLimits(cgroupPath, cgroupName string, res *ResourceConfig) error {
+	setMemory := func() error {
+		if len(res.MemoryLimit) == 0 {
+			return nil
+		}
+		memory, _ := util.ParseBytes(res.MemoryLimit)
+		if err := writeCgroupFile(cgroupPath, "memory.limit_in_bytes", strconv.FormatInt(memory, 10)); err != nil {
+			return fmt.Errorf("failed to set memory limit of %v: %v", cgroupName, err)
+		}
+		return nil
+	}
+	if len(res.MemorySwap) == 0 {
+		return setMemory()
+	}
+
+	if _, err := os.Stat(path.Join(cgroupPath, "memory.memsw.limit_in_bytes")); err != nil {
+		return fmt.Errorf("memory swap limit is not supported, check whether swapaccount=1 is enabled: %v", err)
+	}
+	swap, _ := util.ParseBytes(res.MemorySwap)
+	current, err := readCgroupUint(cgroupPath, "memory.memsw.limit_in_bytes")
+	if err != nil {
+		return err
+	}
+	swapFirst := swap < 0 || uint64(swap) > current
+	if !swapFirst {
+		if err = setMemory(); err != nil {
+			return err
+		}
+	}
+	if err = writeCgroupFile(cgroupPath, "memory.memsw.limit_in_bytes", strconv.FormatInt(swap, 10)); err != nil {
+		return fmt.Errorf("failed to set memory swap limit of %v: %v", cgroupName, err)
+	}
+	if swapFirst {
+		return setMemory()
+	}
+	return nil
+}
+
+// setUnified 设置 cgroup v2 下的内存限制：memory.max 只接受字节数或 max，memory.swap.max 只包含 swap 的部分
+func (s *MemorySubsystem) setUnified(cgroupPath, cgroupName string, res *ResourceConfig) error {
+	if res.OOMKillDisable {
+		logrus.Warningf("oom kill disable is not supported on cgroup v2, ignore it for %v", cgroupName)
+	}
+	if len(res.MemoryLimit) > 0 {
+		limit, err := unifiedMemoryValue(res.MemoryLimit)
+		if err != nil {
+			logrus.Errorf("invalid memory limit %v: %v", res.MemoryLimit, err)
+			return err
+		}
+		if err = writeCgroupFile(cgroupPath, "memory.max", limit); err != nil {
+			return fmt.Errorf("failed to set memory limit of %v: %v", cgroupName, err)
+		}
+	}
+	if len(res.MemorySwap) > 0 {
+		swap, _ := util.ParseBytes(res.MemorySwap)
+		value := "max"
+		if swap >= 0 {
+			memory, _ := util.ParseBytes(res.MemoryLimit)
+			value = strconv.FormatInt(swap-memory, 10)
+		}
+		if err := writeCgroupFile(cgroupPath, "memory.swap.max", value); err != nil {
+			return fmt.Errorf("failed to set memory swap limit of %v: %v", cgroupName, err)
+		}
 	}
 	return nil
 }
 
 func (s MemorySubsystem) Apply(cgroupName string, pid int) error {
 	// 获取自定义 Cgroup 的路径，没有则创建，如：/sys/fs/cgroup/memory/my-runc-cgroup
-	cgroupPath, err := util.GetCgroupPath(s.Name(), cgroupName)
+	cgroupPath, err := getCgroupPath(s.Name(), cgroupName)
 	if err != nil {
 		logrus.Infof("failed to get path of cgroup (%v) in (%v): %v", cgroupName, s.Name(), err)
 		return err
@@ -48,7 +130,7 @@ func (s MemorySubsystem) Apply(cgroupName string, pid int) error {
 	logrus.Infof("path of cgroup (%v) in (%v) is %v", cgroupName, s.Name(), cgroupPath)
 
 	// 将 PID 加入该 cgroup
-	limitFilePath := path.Join(cgroupPath, "tasks")
+	limitFilePath := path.Join(cgroupPath, "cgroup.procs")
 	if err = ioutil.WriteFile(limitFilePath, []byte(strconv.Itoa(pid)), 0644); err != nil {
 		logrus.Errorf("failed to add pid to %v: %v", cgroupName, err)
 		return fmt.Errorf("failed to add pid to %v: %v", cgroupName, err)
@@ -58,7 +140,7 @@ func (s MemorySubsystem) Apply(cgroupName string, pid int) error {
 
 func (s *MemorySubsystem) Remove(cgroupName string) error {
 	// 获取自定义 Cgroup 的路径，没有则创建，如：/sys/fs/cgroup/memory/my-runc-cgroup
-	cgroupPath, err := util.GetCgroupPath(s.Name(), cgroupName)
+	cgroupPath, err := getCgroupPath(s.Name(), cgroupName)
 	if err != nil {
 		logrus.Errorf("failed to get path of cgroup (%v) in (%v): %v", cgroupName, s.Name(), err)
 		return err
@@ -66,3 +148,77 @@ func (s *MemorySubsystem) Remove(cgroupName string) error {
 	logrus.Infof("try to remove path of cgroup (%v) in (%v) is %v", cgroupName, s.Name(), cgroupPath)
 	return os.RemoveAll(cgroupPath)
 }
+
+func (s *MemorySubsystem) GetStats(cgroupName string, stats *Stats) error {
+	cgroupPath, err := findCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		return err
+	}
+	usageFile, limitFile := "memory.usage_in_bytes", "memory.limit_in_bytes"
+	if util.IsCgroup2UnifiedMode() {
+		usageFile, limitFile = "memory.current", "memory.max"
+	}
+	if stats.Memory.Usage, err = readCgroupUint(cgroupPath, usageFile); err != nil {
+		return err
+	}
+	if stats.Memory.Limit, err = readCgroupUint(cgroupPath, limitFile); err != nil {
+		return err
+	}
+	// 被 OOM killer 杀死的进程数：cgroup v1 中在 memory.oom_control 里（4.13 以上的内核），v2 中在 memory.events 里
+	eventsFile := "memory.oom_control"
+	if util.IsCgroup2UnifiedMode() {
+		eventsFile = "memory.events"
+	}
+	var events map[string]uint64
+	if events, err = readCgroupKeyValues(cgroupPath, eventsFile); err != nil {
+		return err
+	}
+	stats.Memory.OOMKills = events["oom_kill"]
+	if util.IsCgroup2UnifiedMode() {
+		stats.Memory.Events = &MemoryEvents{
+			Low:     events["low"],
+			High:    events["high"],
+			Max:     events["max"],
+			OOM:     events["oom"],
+			OOMKill: events["oom_kill"],
+		}
+	}
+	return nil
+}
+
+// validateMemory 校验内存相关的限制：设置 swap 时必须同时设置内存上限，且总上限不能小于内存上限
+func validateMemory(res *ResourceConfig) error {
+	var memory int64
+	var err error
+	if len(res.MemoryLimit) > 0 {
+		if memory, err = util.ParseBytes(res.MemoryLimit); err != nil {
+			return fmt.Errorf("invalid memory limit %q: %v", res.MemoryLimit, err)
+		}
+	}
+	if len(res.MemorySwap) == 0 {
+		return nil
+	}
+	var swap int64
+	if swap, err = util.ParseBytes(res.MemorySwap); err != nil {
+		return fmt.Errorf("invalid memory swap %q: %v", res.MemorySwap, err)
+	}
+	if len(res.MemoryLimit) == 0 || memory < 0 {
+		return fmt.Errorf("memory swap can only be set together with a memory limit")
+	}
+	if swap >= 0 && swap < memory {
+		return fmt.Errorf("memory swap %v should not be smaller than memory limit %v", res.MemorySwap, res.MemoryLimit)
+	}
+	return nil
+}
+
+// unifiedMemoryValue 将内存大小转换为 cgroup v2 接受的格式，-1 表示不限制
+func unifiedMemoryValue(size string) (string, error) {
+	bytes, err := util.ParseBytes(size)
+	if err != nil {
+		return "", err
+	}
+	if bytes < 0 {
+		return "max", nil
+	}
+	return strconv.FormatInt(bytes, 10), nil
+}
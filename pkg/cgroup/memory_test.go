@@ -0,0 +1,81 @@
+package cgroup
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateMemory(t *testing.T) {
+	tests := []struct {
+		name   string
+		res    *ResourceConfig
+		hasErr bool
+	}{
+		{name: "memory limit with unit", res: &ResourceConfig{MemoryLimit: "100m"}},
+		{name: "fractional memory limit", res: &ResourceConfig{MemoryLimit: "1.5g"}},
+		{name: "unlimited memory", res: &ResourceConfig{MemoryLimit: "-1"}},
+		{name: "memory and swap", res: &ResourceConfig{MemoryLimit: "100MB", MemorySwap: "200MB"}},
+		{name: "unlimited swap", res: &ResourceConfig{MemoryLimit: "100m", MemorySwap: "-1"}},
+		{name: "invalid memory limit", res: &ResourceConfig{MemoryLimit: "abc"}, hasErr: true},
+		{name: "invalid swap", res: &ResourceConfig{MemoryLimit: "100m", MemorySwap: "abc"}, hasErr: true},
+		{name: "swap without memory limit", res: &ResourceConfig{MemorySwap: "200m"}, hasErr: true},
+		{name: "swap with unlimited memory", res: &ResourceConfig{MemoryLimit: "-1", MemorySwap: "200m"}, hasErr: true},
+		{name: "swap smaller than memory", res: &ResourceConfig{MemoryLimit: "200m", MemorySwap: "100m"}, hasErr: true},
+	}
+	for _, test := range tests {
+		err := validateMemory(test.res)
+		if test.hasErr {
+			assert.NotEqual(t, nil, err, test.name)
+		} else {
+			assert.Equal(t, nil, err, test.name)
+		}
+	}
+}
+
+func TestMemorySetLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		res    *ResourceConfig
+		memory string
+		memsw  string
+	}{
+		{name: "integer with unit", res: &ResourceConfig{MemoryLimit: "100m"}, memory: "104857600"},
+		{name: "fractional size", res: &ResourceConfig{MemoryLimit: "1.5g"}, memory: "1610612736"},
+		{name: "size with byte suffix", res: &ResourceConfig{MemoryLimit: "2GB"}, memory: "2147483648"},
+		{name: "unlimited", res: &ResourceConfig{MemoryLimit: "-1"}, memory: "-1"},
+		{
+			name:   "memory and swap",
+			res:    &ResourceConfig{MemoryLimit: "100mb", MemorySwap: "1.5g"},
+			memory: "104857600",
+			memsw:  "1610612736",
+		},
+		{
+			name:   "unlimited swap",
+			res:    &ResourceConfig{MemoryLimit: "100m", MemorySwap: "-1"},
+			memory: "104857600",
+			memsw:  "-1",
+		},
+	}
+	for _, test := range tests {
+		cgroupPath, err := ioutil.TempDir("", "memory")
+		assert.Equal(t, nil, err)
+		// memory.memsw.limit_in_bytes 初始为不限制
+		assert.Equal(t, nil, ioutil.WriteFile(path.Join(cgroupPath, "memory.memsw.limit_in_bytes"),
+			[]byte("9223372036854771712\n"), 0644))
+
+		assert.Equal(t, nil, (&MemorySubsystem{}).setLimits(cgroupPath, "test", test.res), test.name)
+		memory, err := readCgroupFile(cgroupPath, "memory.limit_in_bytes")
+		assert.Equal(t, nil, err, test.name)
+		assert.Equal(t, test.memory, memory, test.name)
+		if len(test.memsw) > 0 {
+			memsw, err := readCgroupFile(cgroupPath, "memory.memsw.limit_in_bytes")
+			assert.Equal(t, nil, err, test.name)
+			assert.Equal(t, test.memsw, memsw, test.name)
+		}
+		_ = os.RemoveAll(cgroupPath)
+	}
+}
@@ -0,0 +1,123 @@
+package cgroup
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wangao1236/my-runc/pkg/util"
+	"golang.org/x/sys/unix"
+)
+
+// NotifyOOM 监听 Cgroup 内的 OOM 事件，每次 OOM 都会向返回的 channel 发送通知，Cgroup 被删除后 channel 会被关闭：
+// 1. cgroup v1 通过 cgroup.event_control 将 eventfd 注册到 memory.oom_control 上；
+// 2. cgroup v2 通过 inotify 监听 memory.events 的修改，并比较其中 oom_kill 的计数
+func (m *Manager) NotifyOOM() (<-chan struct{}, error) {
+	memory := &MemorySubsystem{}
+	cgroupPath, err := findCgroupPath(memory.Name(), m.CgroupName)
+	if err != nil {
+		logrus.Errorf("failed to find cgroup %v in %v: %v", m.CgroupName, memory.Name(), err)
+		return nil, err
+	}
+	if util.IsCgroup2UnifiedMode() {
+		return notifyOOMUnified(cgroupPath)
+	}
+	return notifyOOM(cgroupPath)
+}
+
+func notifyOOM(cgroupPath string) (<-chan struct{}, error) {
+	controlPath := path.Join(cgroupPath, "memory.oom_control")
+	control, err := os.Open(controlPath)
+	if err != nil {
+		logrus.Errorf("failed to open %v: %v", controlPath, err)
+		return nil, err
+	}
+	var efd int
+	if efd, err = unix.Eventfd(0, unix.EFD_CLOEXEC); err != nil {
+		_ = control.Close()
+		logrus.Errorf("failed to create eventfd: %v", err)
+		return nil, err
+	}
+	event := os.NewFile(uintptr(efd), "eventfd")
+	if err = writeCgroupFile(cgroupPath, "cgroup.event_control", fmt.Sprintf("%d %d", efd, control.Fd())); err != nil {
+		_ = event.Close()
+		_ = control.Close()
+		return nil, fmt.Errorf("failed to register oom event of %v: %v", cgroupPath, err)
+	}
+
+	ch := make(chan struct{})
+	go func() {
+		defer func() {
+			close(ch)
+			_ = event.Close()
+			_ = control.Close()
+		}()
+		buf := make([]byte, 8)
+		for {
+			if _, readErr := event.Read(buf); readErr != nil {
+				return
+			}
+			// cgroup 被删除时 eventfd 也会被触发
+			if _, statErr := os.Stat(controlPath); os.IsNotExist(statErr) {
+				return
+			}
+			// 一次触发可能对应多次 OOM，计数只用于判断是否发生过，这里只通知一次
+			if binary.LittleEndian.Uint64(buf) > 0 {
+				ch <- struct{}{}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func notifyOOMUnified(cgroupPath string) (<-chan struct{}, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		logrus.Errorf("failed to init inotify: %v", err)
+		return nil, err
+	}
+	eventsPath := path.Join(cgroupPath, "memory.events")
+	if _, err = unix.InotifyAddWatch(fd, eventsPath, unix.IN_MODIFY); err != nil {
+		_ = unix.Close(fd)
+		logrus.Errorf("failed to watch %v: %v", eventsPath, err)
+		return nil, err
+	}
+	var events map[string]uint64
+	if events, err = readCgroupKeyValues(cgroupPath, "memory.events"); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+
+	ch := make(chan struct{})
+	go func() {
+		inotify := os.NewFile(uintptr(fd), "inotify")
+		defer func() {
+			close(ch)
+			_ = inotify.Close()
+		}()
+		oomKills := events["oom_kill"]
+		buf := make([]byte, unix.SizeofInotifyEvent+unix.PathMax+1)
+		for {
+			if _, readErr := inotify.Read(buf); readErr != nil {
+				return
+			}
+			current, readErr := readCgroupKeyValues(cgroupPath, "memory.events")
+			if readErr != nil {
+				return
+			}
+			if current["oom_kill"] > oomKills {
+				oomKills = current["oom_kill"]
+				ch <- struct{}{}
+			}
+			// cgroup 内已经没有进程，不会再有新的 OOM 事件
+			var cgroupEvents map[string]uint64
+			if cgroupEvents, readErr = readCgroupKeyValues(cgroupPath, "cgroup.events"); readErr != nil ||
+				cgroupEvents["populated"] == 0 {
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
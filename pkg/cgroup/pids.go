@@ -0,0 +1,91 @@
+package cgroup
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+var _ Subsystem = &PidsSubsystem{}
+
+// PidsSubsystem 通过 pids.max 限制 cgroup 内的进程数，防止 fork 炸弹耗尽主机的 PID
+type PidsSubsystem struct {
+}
+
+func (s *PidsSubsystem) Name() string {
+	return "pids"
+}
+
+func (s *PidsSubsystem) Set(cgroupName string, res *ResourceConfig) error {
+	if res.PidsLimit == 0 {
+		return nil
+	}
+	cgroupPath, err := getCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("path of cgroup (%v) in (%v) is %v", cgroupName, s.Name(), cgroupPath)
+
+	// cgroup v1 和 v2 都使用 pids.max，负数表示不限制
+	limit := "max"
+	if res.PidsLimit > 0 {
+		limit = strconv.FormatInt(res.PidsLimit, 10)
+	}
+	if err = writeCgroupFile(cgroupPath, "pids.max", limit); err != nil {
+		return fmt.Errorf("failed to set pids limit of %v: %v", cgroupName, err)
+	}
+	return nil
+}
+
+func (s *PidsSubsystem) Apply(cgroupName string, pid int) error {
+	if !controllerMounted(s.Name()) {
+		logrus.Infof("cgroup controller %v is not mounted, skip it", s.Name())
+		return nil
+	}
+	cgroupPath, err := getCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("path of cgroup (%v) in (%v) is %v", cgroupName, s.Name(), cgroupPath)
+
+	// 将 PID 加入该 cgroup
+	if err = writeCgroupFile(cgroupPath, "cgroup.procs", strconv.Itoa(pid)); err != nil {
+		return fmt.Errorf("failed to add pid to %v: %v", cgroupName, err)
+	}
+	return nil
+}
+
+func (s *PidsSubsystem) Remove(cgroupName string) error {
+	if !controllerMounted(s.Name()) {
+		return nil
+	}
+	cgroupPath, err := getCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("try to remove path of cgroup (%v) in (%v) is %v", cgroupName, s.Name(), cgroupPath)
+	return os.RemoveAll(cgroupPath)
+}
+
+func (s *PidsSubsystem) GetStats(cgroupName string, stats *Stats) error {
+	cgroupPath, err := findCgroupPath(s.Name(), cgroupName)
+	if err != nil {
+		return err
+	}
+	if stats.Pids.Current, err = readCgroupUint(cgroupPath, "pids.current"); err != nil {
+		return err
+	}
+	if stats.Pids.Limit, err = readCgroupUint(cgroupPath, "pids.max"); err != nil {
+		return err
+	}
+	// pids.peak 在较新的内核（6.x）中才提供
+	if _, err = os.Stat(path.Join(cgroupPath, "pids.peak")); err == nil {
+		if stats.Pids.Peak, err = readCgroupUint(cgroupPath, "pids.peak"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
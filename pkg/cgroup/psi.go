@@ -0,0 +1,108 @@
+package cgroup
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wangao1236/my-runc/pkg/util"
+)
+
+// PSIData 是某一类资源的压力数据：Avg10、Avg60、Avg300 分别为最近 10s、60s、300s 内任务因等待资源而停顿的时间占比（百分数），
+// Total 是累计停顿的时间，单位为微秒
+type PSIData struct {
+	Avg10  float64 `json:"avg10"`
+	Avg60  float64 `json:"avg60"`
+	Avg300 float64 `json:"avg300"`
+	Total  uint64  `json:"total"`
+}
+
+// PSIStats 对应一个 *.pressure 文件：Some 表示至少有一个任务停顿，Full 表示所有非空闲任务同时停顿
+type PSIStats struct {
+	Some PSIData `json:"some"`
+	Full PSIData `json:"full"`
+}
+
+// PressureStats 表示 cgroup 的 CPU、内存和 IO 压力，内核没有开启 PSI 时对应的字段为空
+type PressureStats struct {
+	CPU    *PSIStats `json:"cpu,omitempty"`
+	Memory *PSIStats `json:"memory,omitempty"`
+	IO     *PSIStats `json:"io,omitempty"`
+}
+
+// getPressureStats 读取 cgroup v2 下 cgroupName 的 cpu.pressure、memory.pressure 和 io.pressure，
+// 内核没有开启 PSI（CONFIG_PSI 或启动参数 psi=0）时读取会失败，此时只打印警告
+func getPressureStats(cgroupName string) *PressureStats {
+	cgroupPath := path.Join(util.CgroupRootDir, cgroupName)
+	pressure := &PressureStats{}
+	var err error
+	if pressure.CPU, err = readPSI(cgroupPath, "cpu.pressure"); err != nil {
+		logrus.Warningf("failed to read cpu pressure of %v: %v", cgroupName, err)
+	}
+	if pressure.Memory, err = readPSI(cgroupPath, "memory.pressure"); err != nil {
+		logrus.Warningf("failed to read memory pressure of %v: %v", cgroupName, err)
+	}
+	if pressure.IO, err = readPSI(cgroupPath, "io.pressure"); err != nil {
+		logrus.Warningf("failed to read io pressure of %v: %v", cgroupName, err)
+	}
+	if pressure.CPU == nil && pressure.Memory == nil && pressure.IO == nil {
+		return nil
+	}
+	return pressure
+}
+
+func readPSI(cgroupPath, file string) (*PSIStats, error) {
+	body, err := readCgroupFile(cgroupPath, file)
+	if err != nil {
+		return nil, err
+	}
+	return parsePSI(body)
+}
+
+// parsePSI 解析 *.pressure 文件，格式为：
+//
+//	some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+//	full avg10=0.00 avg60=0.00 avg300=0.00 total=0
+//
+// 5.13 以前的内核中 cpu.pressure 没有 full 这一行
+func parsePSI(body string) (*PSIStats, error) {
+	psi := &PSIStats{}
+	for _, line := range strings.Split(body, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		var data *PSIData
+		switch fields[0] {
+		case "some":
+			data = &psi.Some
+		case "full":
+			data = &psi.Full
+		default:
+			return nil, fmt.Errorf("invalid line %q", line)
+		}
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid field %q in line %q", field, line)
+			}
+			var err error
+			switch kv[0] {
+			case "avg10":
+				data.Avg10, err = strconv.ParseFloat(kv[1], 64)
+			case "avg60":
+				data.Avg60, err = strconv.ParseFloat(kv[1], 64)
+			case "avg300":
+				data.Avg300, err = strconv.ParseFloat(kv[1], 64)
+			case "total":
+				data.Total, err = strconv.ParseUint(kv[1], 10, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid field %q in line %q: %v", field, line, err)
+			}
+		}
+	}
+	return psi, nil
+}
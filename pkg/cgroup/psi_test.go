@@ -0,0 +1,26 @@
+package cgroup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePSI(t *testing.T) {
+	psi, err := parsePSI("some avg10=1.50 avg60=0.25 avg300=0.00 total=123456\n" +
+		"full avg10=0.10 avg60=0.00 avg300=0.00 total=789")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, PSIData{Avg10: 1.5, Avg60: 0.25, Total: 123456}, psi.Some)
+	assert.Equal(t, PSIData{Avg10: 0.1, Total: 789}, psi.Full)
+
+	// 老版本内核的 cpu.pressure 只有 some
+	psi, err = parsePSI("some avg10=0.00 avg60=0.00 avg300=0.00 total=42")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(42), psi.Some.Total)
+	assert.Equal(t, PSIData{}, psi.Full)
+
+	_, err = parsePSI("some avg10=abc")
+	assert.NotEqual(t, nil, err)
+	_, err = parsePSI("partial avg10=0.00")
+	assert.NotEqual(t, nil, err)
+}
@@ -0,0 +1,118 @@
+package cgroup
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PidsStats 表示 cgroup 内的进程数统计
+type PidsStats struct {
+	// Current 是当前的进程数
+	Current uint64 `json:"current"`
+	// Peak 是历史最大进程数，内核不支持时为 0
+	Peak uint64 `json:"peak"`
+	// Limit 是进程数上限，0 表示不限制
+	Limit uint64 `json:"limit"`
+}
+
+// MemoryStats 表示 cgroup 的内存使用情况，单位为字节
+type MemoryStats struct {
+	Usage uint64 `json:"usage"`
+	// Limit 是内存上限，cgroup v2 下 0 表示不限制，cgroup v1 下不限制时为一个接近 int64 最大值的数
+	Limit uint64 `json:"limit"`
+	// OOMKills 是 cgroup 内被 OOM killer 杀死的进程数
+	OOMKills uint64 `json:"oomKills"`
+	// Events 是 cgroup v2 中 memory.events 的计数，cgroup v1 下为空
+	Events *MemoryEvents `json:"events,omitempty"`
+}
+
+// MemoryEvents 对应 cgroup v2 的 memory.events，记录各个内存边界被触发的次数
+type MemoryEvents struct {
+	// Low 是内存使用低于 memory.low 但仍被回收的次数
+	Low uint64 `json:"low"`
+	// High 是超过 memory.high 被限流并强制回收的次数
+	High uint64 `json:"high"`
+	// Max 是内存使用即将超过 memory.max 的次数
+	Max uint64 `json:"max"`
+	// OOM 是达到 memory.max 后分配失败、进入 OOM 流程的次数
+	OOM uint64 `json:"oom"`
+	// OOMKill 是被 OOM killer 杀死的进程数
+	OOMKill uint64 `json:"oomKill"`
+}
+
+// CPUStats 表示 cgroup 的 CPU 使用情况
+type CPUStats struct {
+	// UsageNanos 是累计使用的 CPU 时间，单位为纳秒
+	UsageNanos uint64 `json:"usageNanos"`
+	// NrThrottled 和 ThrottledNanos 表示因超出 quota 被限流的次数和时长
+	NrThrottled    uint64 `json:"nrThrottled"`
+	ThrottledNanos uint64 `json:"throttledNanos"`
+}
+
+// BlkioStats 表示 cgroup 在所有块设备上的累计读写字节数
+type BlkioStats struct {
+	ReadBytes  uint64 `json:"readBytes"`
+	WriteBytes uint64 `json:"writeBytes"`
+}
+
+// HugetlbStats 表示 cgroup 对某一种大小的大页的使用情况
+type HugetlbStats struct {
+	// Usage 是当前使用的大页字节数
+	Usage uint64 `json:"usage"`
+	// MaxUsage 是历史最大用量，只在 cgroup v1 下提供
+	MaxUsage uint64 `json:"maxUsage,omitempty"`
+	// Failcnt 是因超出上限而分配失败的次数
+	Failcnt uint64 `json:"failcnt"`
+}
+
+// Stats 表示从 cgroup 中读取的资源使用情况
+type Stats struct {
+	Pids   PidsStats   `json:"pids"`
+	Memory MemoryStats `json:"memory"`
+	CPU    CPUStats    `json:"cpu"`
+	Blkio  BlkioStats  `json:"blkio"`
+	// Hugetlb 的 key 为大页大小，如 2MB，没有挂载 hugetlb 时为空
+	Hugetlb map[string]HugetlbStats `json:"hugetlb,omitempty"`
+	// Pressure 是 PSI（pressure stall information），只在 cgroup v2 下提供
+	Pressure *PressureStats `json:"pressure,omitempty"`
+}
+
+// readCgroupKeyValues 读取 cgroupPath 下每行为 "key value" 格式的控制文件，如 cpu.stat、memory.stat
+func readCgroupKeyValues(cgroupPath, file string) (map[string]uint64, error) {
+	filePath := path.Join(cgroupPath, file)
+	f, err := os.Open(filePath)
+	if err != nil {
+		logrus.Errorf("failed to open %v: %v", filePath, err)
+		return nil, err
+	}
+	defer func() {
+		if err = f.Close(); err != nil {
+			logrus.Warningf("failed to close %v: %v", filePath, err)
+		}
+	}()
+
+	result := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		var value uint64
+		if value, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+			logrus.Warningf("invalid line %q in %v: %v", scanner.Text(), filePath, err)
+			continue
+		}
+		result[fields[0]] = value
+	}
+	if err = scanner.Err(); err != nil {
+		logrus.Errorf("failed to scan %v: %v", filePath, err)
+		return nil, err
+	}
+	return result, nil
+}
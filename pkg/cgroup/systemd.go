@@ -0,0 +1,326 @@
+package cgroup
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/sirupsen/logrus"
+	"github.com/wangao1236/my-runc/pkg/util"
+)
+
+const (
+	systemdService    = "org.freedesktop.systemd1"
+	systemdPath       = "/org/freedesktop/systemd1"
+	systemdInterface  = "org.freedesktop.systemd1.Manager"
+	systemdNoSuchUnit = "org.freedesktop.systemd1.NoSuchUnit"
+	systemdUnitExists = "org.freedesktop.systemd1.UnitExists"
+
+	systemdJobTimeout = 30 * time.Second
+)
+
+// systemdV1Controllers 是 cgroup v1 中由 systemd 为 scope 创建 cgroup 的 controller，其余 controller 需要通过 cgroupfs 加入
+var systemdV1Controllers = map[string]bool{
+	"memory":  true,
+	"cpu":     true,
+	"cpuacct": true,
+	"pids":    true,
+	"blkio":   true,
+	"devices": true,
+}
+
+// systemdProperty 对应 D-Bus 接口中 a(sv) 类型的 unit 属性
+type systemdProperty struct {
+	Name  string
+	Value dbus.Variant
+}
+
+// systemdAuxUnit 对应 StartTransientUnit 中 a(sa(sv)) 类型的辅助 unit，这里不会用到
+type systemdAuxUnit struct {
+	Name       string
+	Properties []systemdProperty
+}
+
+func newSystemdProperty(name string, value interface{}) systemdProperty {
+	return systemdProperty{Name: name, Value: dbus.MakeVariant(value)}
+}
+
+// ExpandSlice 将 systemd 的 slice 名称转换为 cgroup 路径，slice 以 "-" 表示层级，如：a-b.slice 转换为 a.slice/a-b.slice
+func ExpandSlice(slice string) (string, error) {
+	const suffix = ".slice"
+	if !strings.HasSuffix(slice, suffix) || len(slice) == len(suffix) || strings.Contains(slice, "/") {
+		return "", fmt.Errorf("invalid slice name %q", slice)
+	}
+	name := strings.TrimSuffix(slice, suffix)
+	// -.slice 表示根 slice
+	if name == "-" {
+		return "", nil
+	}
+	var result, prefix string
+	for _, component := range strings.Split(name, "-") {
+		if len(component) == 0 {
+			return "", fmt.Errorf("invalid slice name %q", slice)
+		}
+		result = path.Join(result, prefix+component+suffix)
+		prefix += component + "-"
+	}
+	return result, nil
+}
+
+// systemdUnitName 返回 cgroupName 对应的 scope 名称和所在的 slice，cgroupName 的格式为 ${slice-path}/${scope}
+func systemdUnitName(cgroupName string) (string, string) {
+	return path.Base(cgroupName), path.Base(path.Dir(cgroupName))
+}
+
+// systemdConnect 连接 systemd 所在的 system bus，DBUS_SYSTEM_BUS_ADDRESS 可以指定其他的总线地址
+func systemdConnect() (*dbus.Conn, dbus.BusObject, error) {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		logrus.Errorf("failed to connect to system bus: %v", err)
+		return nil, nil, fmt.Errorf("failed to connect to system bus: %v", err)
+	}
+	return conn, conn.Object(systemdService, systemdPath), nil
+}
+
+// systemdCallJob 调用 systemd 中返回 job 的方法（如 StartTransientUnit、StopUnit），并等待 job 执行完成
+func systemdCallJob(method string, args ...interface{}) error {
+	conn, obj, err := systemdConnect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	// 需要在调用之前订阅 JobRemoved 信号，否则 job 可能在订阅之前就已经完成
+	if err = conn.AddMatchSignal(dbus.WithMatchInterface(systemdInterface), dbus.WithMatchMember("JobRemoved"),
+		dbus.WithMatchObjectPath(systemdPath)); err != nil {
+		logrus.Errorf("failed to subscribe JobRemoved of systemd: %v", err)
+		return err
+	}
+	signals := make(chan *dbus.Signal, 16)
+	conn.Signal(signals)
+
+	var job dbus.ObjectPath
+	if err = obj.Call(systemdInterface+"."+method, 0, args...).Store(&job); err != nil {
+		logrus.Errorf("failed to call %v of systemd: %v", method, err)
+		return err
+	}
+
+	timeout := time.After(systemdJobTimeout)
+	for {
+		select {
+		case signal := <-signals:
+			// JobRemoved 的参数为 (id uint32, job ObjectPath, unit string, result string)
+			if signal.Name != systemdInterface+".JobRemoved" || len(signal.Body) < 4 || signal.Body[1] != job {
+				continue
+			}
+			if result, _ := signal.Body[3].(string); result != "done" {
+				return fmt.Errorf("systemd job %v of %v failed: %v", job, signal.Body[2], result)
+			}
+			return nil
+		case <-timeout:
+			return fmt.Errorf("timeout waiting for systemd job %v", job)
+		}
+	}
+}
+
+// systemdApply 为 PID 创建一个 transient scope unit，并将资源限制作为 unit 的属性。
+// scope 已经存在时（如 exec 将新进程加入容器）只将 PID 移入 unit。
+// systemd 不会在它不管理的 controller（如 cgroup v1 的 cpuset、freezer）中创建 cgroup，这部分仍需要通过 cgroupfs 加入，
+// systemd 无法表示的限制（如 cpuset、块设备限速、设备白名单）也通过 cgroupfs 设置
+func (m *Manager) systemdApply(pid int) error {
+	unit, slice := systemdUnitName(m.CgroupName)
+	properties := []systemdProperty{
+		newSystemdProperty("Description", "my-runc container "+unit),
+		newSystemdProperty("Slice", slice),
+		newSystemdProperty("PIDs", []uint32{uint32(pid)}),
+		// 由我们自己管理 scope 内的子 cgroup 和 systemd 不支持的控制文件
+		newSystemdProperty("Delegate", true),
+		newSystemdProperty("DefaultDependencies", false),
+		newSystemdProperty("MemoryAccounting", true),
+		newSystemdProperty("CPUAccounting", true),
+		newSystemdProperty("TasksAccounting", true),
+	}
+	if m.Resource != nil {
+		resourceProperties, err := systemdResourceProperties(m.Resource)
+		if err != nil {
+			return err
+		}
+		properties = append(properties, resourceProperties...)
+	}
+	started := true
+	err := systemdCallJob("StartTransientUnit", unit, "replace", properties, []systemdAuxUnit{})
+	switch {
+	case err == nil:
+		logrus.Infof("started transient unit %v in %v for pid %v", unit, slice, pid)
+	case isSystemdError(err, systemdUnitExists):
+		started = false
+		if err = systemdCall("AttachProcessesToUnit", unit, "", []uint32{uint32(pid)}); err != nil {
+			logrus.Errorf("failed to attach pid %v to unit %v: %v", pid, unit, err)
+			return err
+		}
+		logrus.Infof("attached pid %v to unit %v", pid, unit)
+	default:
+		logrus.Errorf("failed to start transient unit %v: %v", unit, err)
+		return err
+	}
+
+	if !util.IsCgroup2UnifiedMode() {
+		for _, ss := range Subsystems {
+			if systemdV1Controllers[ss.Name()] {
+				continue
+			}
+			if err = ss.Apply(m.CgroupName, pid); err != nil {
+				return err
+			}
+		}
+	}
+	// 加入已有的 scope 时资源限制已经设置过了
+	if started && m.Resource != nil {
+		return m.fsSet(systemdUnsupportedResources(m.Resource))
+	}
+	return nil
+}
+
+// systemdSet 修改 scope unit 的属性，unit 还没有创建时（容器进程启动之前）只记录下来，在 Apply 时一起设置
+func (m *Manager) systemdSet(res *ResourceConfig) error {
+	m.Resource = res
+	properties, err := systemdResourceProperties(res)
+	if err != nil {
+		return err
+	}
+	unit, _ := systemdUnitName(m.CgroupName)
+	// 属性为空时也需要调用，以便判断 unit 是否已经创建
+	if err = systemdCall("SetUnitProperties", unit, true, properties); err != nil {
+		if isSystemdError(err, systemdNoSuchUnit) {
+			return nil
+		}
+		logrus.Errorf("failed to set properties of unit %v: %v", unit, err)
+		return err
+	}
+	return m.fsSet(systemdUnsupportedResources(res))
+}
+
+// systemdDestroy 停止 scope unit，systemd 会删除它创建的 cgroup，其余 controller 中的 cgroup 通过 cgroupfs 删除
+func (m *Manager) systemdDestroy() error {
+	unit, _ := systemdUnitName(m.CgroupName)
+	if err := systemdCallJob("StopUnit", unit, "replace"); err != nil && !isSystemdError(err, systemdNoSuchUnit) {
+		logrus.Errorf("failed to stop unit %v: %v", unit, err)
+		return err
+	}
+	logrus.Infof("stopped unit %v", unit)
+	return m.fsDestroy()
+}
+
+func isSystemdError(err error, name string) bool {
+	dbusErr, ok := err.(dbus.Error)
+	return ok && dbusErr.Name == name
+}
+
+func systemdCall(method string, args ...interface{}) error {
+	conn, obj, err := systemdConnect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	return obj.Call(systemdInterface+"."+method, 0, args...).Err
+}
+
+// systemdResourceProperties 将资源限制转换为 unit 属性，cgroup v1 和 v2 下属性名称不同，如 MemoryLimit 和 MemoryMax。
+// systemd 中 uint64 的最大值表示不限制。cgroup v1 中设置了 swap 时内存上限由 cgroupfs 设置，见 systemdUnsupportedResources
+func systemdResourceProperties(res *ResourceConfig) ([]systemdProperty, error) {
+	unified := util.IsCgroup2UnifiedMode()
+	var properties []systemdProperty
+	if len(res.MemoryLimit) > 0 && (unified || len(res.MemorySwap) == 0) {
+		limit, err := util.ParseBytes(res.MemoryLimit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory limit %q: %v", res.MemoryLimit, err)
+		}
+		name := "MemoryLimit"
+		if unified {
+			name = "MemoryMax"
+		}
+		properties = append(properties, newSystemdProperty(name, systemdLimit(limit)))
+		if unified && len(res.MemorySwap) > 0 {
+			swap, err := util.ParseBytes(res.MemorySwap)
+			if err != nil {
+				return nil, fmt.Errorf("invalid memory swap %q: %v", res.MemorySwap, err)
+			}
+			if swap >= 0 {
+				swap -= limit
+			}
+			properties = append(properties, newSystemdProperty("MemorySwapMax", systemdLimit(swap)))
+		}
+	}
+	if len(res.CPUShare) > 0 {
+		share, err := strconv.ParseUint(res.CPUShare, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu share %q: %v", res.CPUShare, err)
+		}
+		if unified {
+			properties = append(properties, newSystemdProperty("CPUWeight", sharesToWeight(share)))
+		} else {
+			properties = append(properties, newSystemdProperty("CPUShares", share))
+		}
+	}
+	if res.CPUQuota != 0 {
+		period := res.CPUPeriod
+		if period == 0 {
+			period = DefaultCPUPeriod
+		}
+		// systemd 以每秒可以使用的 CPU 时间表示 quota，且最小精度为 10ms
+		quota := uint64(math.MaxUint64)
+		if res.CPUQuota > 0 {
+			quota = uint64(res.CPUQuota) * 1000000 / period
+			if quota%10000 != 0 {
+				quota = (quota/10000 + 1) * 10000
+			}
+		}
+		properties = append(properties, newSystemdProperty("CPUQuotaPerSecUSec", quota))
+	}
+	if res.CPUPeriod != 0 {
+		properties = append(properties, newSystemdProperty("CPUQuotaPeriodUSec", res.CPUPeriod))
+	}
+	if res.PidsLimit != 0 {
+		properties = append(properties, newSystemdProperty("TasksMax", systemdLimit(res.PidsLimit)))
+	}
+	if res.BlkioWeight != 0 {
+		if unified {
+			properties = append(properties, newSystemdProperty("IOWeight", blkioWeightToIOWeight(res.BlkioWeight)))
+		} else {
+			properties = append(properties, newSystemdProperty("BlockIOWeight", uint64(res.BlkioWeight)))
+		}
+	}
+	return properties, nil
+}
+
+// systemdUnsupportedResources 返回 res 中 systemdResourceProperties 无法表示、需要通过 cgroupfs 设置的部分，
+// 避免与 systemd 同时写入相同的控制文件。cgroup v1 中 systemd 不支持 swap 的上限，
+// 而内存上限与 swap 上限的写入顺序有要求，因此设置了 swap 时两者都通过 cgroupfs 设置
+func systemdUnsupportedResources(res *ResourceConfig) *ResourceConfig {
+	unsupported := *res
+	if util.IsCgroup2UnifiedMode() || len(res.MemorySwap) == 0 {
+		unsupported.MemoryLimit = ""
+		unsupported.MemorySwap = ""
+	}
+	unsupported.CPUShare = ""
+	unsupported.CPUQuota = 0
+	unsupported.CPUPeriod = 0
+	unsupported.PidsLimit = 0
+	unsupported.BlkioWeight = 0
+	return &unsupported
+}
+
+func systemdLimit(limit int64) uint64 {
+	if limit < 0 {
+		return math.MaxUint64
+	}
+	return uint64(limit)
+}
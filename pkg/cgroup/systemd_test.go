@@ -0,0 +1,340 @@
+package cgroup
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/wangao1236/my-runc/pkg/util"
+)
+
+func TestExpandSlice(t *testing.T) {
+	cases := map[string]string{
+		"-.slice":       "",
+		"system.slice":  "system.slice",
+		"my-runc.slice": "my.slice/my-runc.slice",
+		"a-b-c.slice":   "a.slice/a-b.slice/a-b-c.slice",
+	}
+	for slice, expected := range cases {
+		result, err := ExpandSlice(slice)
+		assert.Equal(t, nil, err, slice)
+		assert.Equal(t, expected, result, slice)
+	}
+
+	for _, invalid := range []string{"", ".slice", "system", "a--b.slice", "a/b.slice", "-a.slice"} {
+		_, err := ExpandSlice(invalid)
+		assert.NotEqual(t, nil, err, invalid)
+	}
+}
+
+func TestSystemdUnitName(t *testing.T) {
+	unit, slice := systemdUnitName("my.slice/my-runc.slice/my-runc-1281457058.scope")
+	assert.Equal(t, "my-runc-1281457058.scope", unit)
+	assert.Equal(t, "my-runc.slice", slice)
+	assert.Equal(t, true, isSystemdUnit("my.slice/my-runc.slice/my-runc-1281457058.scope"))
+	assert.Equal(t, false, isSystemdUnit("my-runc/1281457058"))
+}
+
+func TestIsSystemdError(t *testing.T) {
+	assert.Equal(t, true, isSystemdError(dbus.Error{Name: systemdUnitExists}, systemdUnitExists))
+	assert.Equal(t, false, isSystemdError(dbus.Error{Name: systemdNoSuchUnit}, systemdUnitExists))
+	assert.Equal(t, false, isSystemdError(fmt.Errorf(systemdUnitExists), systemdUnitExists))
+}
+
+func TestSystemdResourceProperties(t *testing.T) {
+	unified := util.IsCgroup2UnifiedMode()
+	res := &ResourceConfig{
+		MemoryLimit: "100m",
+		MemorySwap:  "200m",
+		CPUShare:    "1024",
+		CPUQuota:    50000,
+		CPUPeriod:   100000,
+		PidsLimit:   -1,
+		BlkioWeight: 500,
+	}
+	properties, err := systemdResourceProperties(res)
+	assert.Equal(t, nil, err)
+	values := make(map[string]interface{})
+	for _, p := range properties {
+		values[p.Name] = p.Value.Value()
+	}
+	assert.Equal(t, uint64(500000), values["CPUQuotaPerSecUSec"])
+	assert.Equal(t, uint64(100000), values["CPUQuotaPeriodUSec"])
+	assert.Equal(t, uint64(math.MaxUint64), values["TasksMax"])
+	if unified {
+		assert.Equal(t, uint64(100<<20), values["MemoryMax"])
+		assert.Equal(t, uint64(100<<20), values["MemorySwapMax"])
+		assert.Equal(t, sharesToWeight(1024), values["CPUWeight"])
+		assert.Equal(t, blkioWeightToIOWeight(500), values["IOWeight"])
+		assert.Equal(t, nil, values["MemoryLimit"])
+	} else {
+		// 设置了 swap 时内存上限只通过 cgroupfs 设置
+		assert.Equal(t, nil, values["MemoryLimit"])
+		assert.Equal(t, uint64(1024), values["CPUShares"])
+		assert.Equal(t, uint64(500), values["BlockIOWeight"])
+		assert.Equal(t, nil, values["MemorySwapMax"])
+
+		properties, err = systemdResourceProperties(&ResourceConfig{MemoryLimit: "100m"})
+		assert.Equal(t, nil, err)
+		assert.Equal(t, []systemdProperty{newSystemdProperty("MemoryLimit", uint64(100<<20))}, properties)
+	}
+
+	_, err = systemdResourceProperties(&ResourceConfig{MemoryLimit: "abc"})
+	assert.NotEqual(t, nil, err)
+}
+
+func TestSystemdUnsupportedResources(t *testing.T) {
+	device := &ThrottleDevice{Major: 8, Minor: 0, Rate: 1024}
+	res := &ResourceConfig{
+		MemoryLimit:                "100m",
+		CPUShare:                   "1024",
+		CPUQuota:                   50000,
+		CPUSet:                     "0",
+		PidsLimit:                  10,
+		BlkioWeight:                500,
+		BlkioThrottleReadBpsDevice: []*ThrottleDevice{device},
+		Devices:                    DefaultDevices(),
+	}
+	assert.Equal(t, &ResourceConfig{
+		CPUSet:                     "0",
+		BlkioThrottleReadBpsDevice: []*ThrottleDevice{device},
+		Devices:                    DefaultDevices(),
+	}, systemdUnsupportedResources(res))
+	// 不能修改原有的配置
+	assert.Equal(t, "100m", res.MemoryLimit)
+
+	res = &ResourceConfig{MemoryLimit: "100m", MemorySwap: "200m"}
+	if util.IsCgroup2UnifiedMode() {
+		assert.Equal(t, &ResourceConfig{}, systemdUnsupportedResources(res))
+	} else {
+		assert.Equal(t, res, systemdUnsupportedResources(res))
+	}
+}
+
+// fakeSystemd 在私有总线上模拟 systemd 的 Manager 接口，记录每个 unit 的属性和其中的 PID
+type fakeSystemd struct {
+	conn       *dbus.Conn
+	lock       sync.Mutex
+	jobs       uint32
+	properties map[string]map[string]interface{}
+	pids       map[string][]uint32
+	calls      []string
+}
+
+func (f *fakeSystemd) StartTransientUnit(name, mode string, properties []systemdProperty,
+	aux []systemdAuxUnit) (dbus.ObjectPath, *dbus.Error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.calls = append(f.calls, "StartTransientUnit")
+	if _, ok := f.properties[name]; ok {
+		return "", dbus.NewError(systemdUnitExists, []interface{}{"Unit " + name + " already exists."})
+	}
+	f.properties[name] = make(map[string]interface{})
+	f.setProperties(name, properties)
+	f.pids[name] = f.properties[name]["PIDs"].([]uint32)
+	return f.finishJob(name), nil
+}
+
+func (f *fakeSystemd) AttachProcessesToUnit(name, subcgroup string, pids []uint32) *dbus.Error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.calls = append(f.calls, "AttachProcessesToUnit")
+	if _, ok := f.properties[name]; !ok {
+		return dbus.NewError(systemdNoSuchUnit, []interface{}{"Unit " + name + " not loaded."})
+	}
+	f.pids[name] = append(f.pids[name], pids...)
+	return nil
+}
+
+func (f *fakeSystemd) SetUnitProperties(name string, runtime bool, properties []systemdProperty) *dbus.Error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.calls = append(f.calls, "SetUnitProperties")
+	if _, ok := f.properties[name]; !ok {
+		return dbus.NewError(systemdNoSuchUnit, []interface{}{"Unit " + name + " not loaded."})
+	}
+	f.setProperties(name, properties)
+	return nil
+}
+
+func (f *fakeSystemd) StopUnit(name, mode string) (dbus.ObjectPath, *dbus.Error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.calls = append(f.calls, "StopUnit")
+	if _, ok := f.properties[name]; !ok {
+		return "", dbus.NewError(systemdNoSuchUnit, []interface{}{"Unit " + name + " not loaded."})
+	}
+	delete(f.properties, name)
+	delete(f.pids, name)
+	return f.finishJob(name), nil
+}
+
+func (f *fakeSystemd) setProperties(name string, properties []systemdProperty) {
+	for _, p := range properties {
+		f.properties[name][p.Name] = p.Value.Value()
+	}
+}
+
+// finishJob 分配一个 job 并立即发出 JobRemoved 信号，调用方在调用之前已经订阅了该信号
+func (f *fakeSystemd) finishJob(name string) dbus.ObjectPath {
+	f.jobs++
+	job := dbus.ObjectPath(fmt.Sprintf("%v/job/%v", systemdPath, f.jobs))
+	_ = f.conn.Emit(systemdPath, systemdInterface+".JobRemoved", f.jobs, job, name, "done")
+	return job
+}
+
+// unit 返回 unit 的属性和其中的 PID，unit 不存在时属性为 nil
+func (f *fakeSystemd) unit(name string) (map[string]interface{}, []uint32) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	properties := f.properties[name]
+	if properties == nil {
+		return nil, nil
+	}
+	result := make(map[string]interface{})
+	for k, v := range properties {
+		result[k] = v
+	}
+	return result, append([]uint32(nil), f.pids[name]...)
+}
+
+func (f *fakeSystemd) reset() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+// startFakeSystemd 启动一个私有的 dbus-daemon 作为 system bus，并在其上注册 fakeSystemd，没有 dbus-daemon 时跳过测试
+func startFakeSystemd(t *testing.T) (*fakeSystemd, func()) {
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon is not available")
+	}
+	dir, err := ioutil.TempDir("", "dbus")
+	assert.Equal(t, nil, err)
+	config := fmt.Sprintf(`<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>system</type>
+  <listen>unix:path=%v</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`, path.Join(dir, "system_bus_socket"))
+	assert.Equal(t, nil, ioutil.WriteFile(path.Join(dir, "system.conf"), []byte(config), 0644))
+
+	cmd := exec.Command(daemon, "--config-file="+path.Join(dir, "system.conf"), "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	assert.Equal(t, nil, err)
+	if err = cmd.Start(); err != nil {
+		_ = os.RemoveAll(dir)
+		t.Skipf("failed to start dbus-daemon: %v", err)
+	}
+	stop := func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		_ = os.RemoveAll(dir)
+	}
+	// dbus-daemon 在可以接受连接之后才会输出地址
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		stop()
+		t.Skipf("failed to read address of dbus-daemon: %v", err)
+	}
+	address = strings.TrimSpace(address)
+
+	conn, err := dbus.Connect(address)
+	assert.Equal(t, nil, err)
+	fake := &fakeSystemd{
+		conn:       conn,
+		properties: make(map[string]map[string]interface{}),
+		pids:       make(map[string][]uint32),
+	}
+	assert.Equal(t, nil, conn.Export(fake, systemdPath, systemdInterface))
+	reply, err := conn.RequestName(systemdService, dbus.NameFlagDoNotQueue)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, dbus.RequestNameReplyPrimaryOwner, reply)
+
+	oldAddress, hasAddress := os.LookupEnv("DBUS_SYSTEM_BUS_ADDRESS")
+	assert.Equal(t, nil, os.Setenv("DBUS_SYSTEM_BUS_ADDRESS", address))
+	return fake, func() {
+		if hasAddress {
+			_ = os.Setenv("DBUS_SYSTEM_BUS_ADDRESS", oldAddress)
+		} else {
+			_ = os.Unsetenv("DBUS_SYSTEM_BUS_ADDRESS")
+		}
+		_ = conn.Close()
+		stop()
+	}
+}
+
+func TestSystemdManager(t *testing.T) {
+	fake, cleanup := startFakeSystemd(t)
+	defer cleanup()
+	// 只测试 D-Bus 的调用，不在宿主机上创建 cgroup
+	subsystems := Subsystems
+	Subsystems = nil
+	defer func() {
+		Subsystems = subsystems
+	}()
+
+	const unit = "my-runc-1281457058.scope"
+	memoryProperty := "MemoryLimit"
+	if util.IsCgroup2UnifiedMode() {
+		memoryProperty = "MemoryMax"
+	}
+	m := NewSystemdManager("my.slice/my-runc.slice/" + unit)
+
+	// unit 还没有创建时只记录资源限制
+	assert.Equal(t, nil, m.Set(&ResourceConfig{MemoryLimit: "100m", PidsLimit: 10}))
+	assert.Equal(t, []string{"SetUnitProperties"}, fake.reset())
+	properties, _ := fake.unit(unit)
+	assert.Equal(t, map[string]interface{}(nil), properties)
+
+	// 创建 scope 时带上记录的资源限制
+	assert.Equal(t, nil, m.Apply(100))
+	assert.Equal(t, []string{"StartTransientUnit"}, fake.reset())
+	properties, pids := fake.unit(unit)
+	assert.Equal(t, "my-runc.slice", properties["Slice"])
+	assert.Equal(t, true, properties["Delegate"])
+	assert.Equal(t, uint64(100<<20), properties[memoryProperty])
+	assert.Equal(t, uint64(10), properties["TasksMax"])
+	assert.Equal(t, []uint32{100}, pids)
+
+	// exec 的进程加入已有的 scope
+	assert.Equal(t, nil, NewSystemdManager(m.CgroupName).Apply(200))
+	assert.Equal(t, []string{"StartTransientUnit", "AttachProcessesToUnit"}, fake.reset())
+	_, pids = fake.unit(unit)
+	assert.Equal(t, []uint32{100, 200}, pids)
+
+	// update 修改已有 unit 的属性
+	assert.Equal(t, nil, m.Set(&ResourceConfig{MemoryLimit: "200m"}))
+	assert.Equal(t, []string{"SetUnitProperties"}, fake.reset())
+	properties, _ = fake.unit(unit)
+	assert.Equal(t, uint64(200<<20), properties[memoryProperty])
+
+	assert.Equal(t, nil, m.Destroy())
+	assert.Equal(t, []string{"StopUnit"}, fake.reset())
+	properties, _ = fake.unit(unit)
+	assert.Equal(t, map[string]interface{}(nil), properties)
+	// unit 已经不存在时 Destroy 不会报错
+	assert.Equal(t, nil, m.Destroy())
+	// 不存在的 unit 无法加入进程
+	assert.Equal(t, true, isSystemdError(systemdCall("AttachProcessesToUnit", unit, "", []uint32{300}),
+		systemdNoSuchUnit))
+}
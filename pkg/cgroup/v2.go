@@ -0,0 +1,164 @@
+package cgroup
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wangao1236/my-runc/pkg/util"
+)
+
+// cgroup v2 中所有 controller 都挂在同一个层级下，部分 controller 的名称与 v1 不同，
+// freezer 在 cgroup v2 中由每个 cgroup 的 cgroup.freeze 提供，devices 由 eBPF 程序实现，都不需要启用 controller
+var unifiedControllers = map[string]string{
+	"blkio":   "io",
+	"cpuacct": "cpu",
+	"freezer": "",
+	"devices": "",
+}
+
+// getCgroupPath 返回 subsystem 下自定义 Cgroup 的路径，没有则创建：
+// 1. cgroup v1 下每个 subsystem 有单独的层级，如：/sys/fs/cgroup/memory/my-runc-cgroup，controller 没有挂载时返回明确的错误；
+// 2. cgroup v2 下所有 subsystem 共用一个目录，如：/sys/fs/cgroup/my-runc-cgroup，同时会在祖先节点中启用对应的 controller
+func getCgroupPath(subsystem, cgroupName string) (string, error) {
+	if util.IsCgroup2UnifiedMode() {
+		return getUnifiedCgroupPath(subsystem, cgroupName)
+	}
+	if _, err := util.FindCgroupMountPoint(subsystem); err != nil {
+		logrus.Errorf("cgroup controller %v is not mounted: %v", subsystem, err)
+		return "", fmt.Errorf("cgroup controller %v is not mounted, check /proc/self/mountinfo", subsystem)
+	}
+	cgroupPath, err := util.GetCgroupPath(subsystem, cgroupName)
+	if err != nil {
+		logrus.Errorf("failed to get path of cgroup (%v) in (%v): %v", cgroupName, subsystem, err)
+		return "", err
+	}
+	return cgroupPath, nil
+}
+
+// findCgroupPath 返回 subsystem 下已经存在的自定义 Cgroup 的路径，与 getCgroupPath 不同，不存在时不会创建而是返回错误
+func findCgroupPath(subsystem, cgroupName string) (string, error) {
+	root := util.CgroupRootDir
+	if !util.IsCgroup2UnifiedMode() {
+		var err error
+		if root, err = util.FindCgroupMountPoint(subsystem); err != nil {
+			return "", fmt.Errorf("cgroup controller %v is not mounted, check /proc/self/mountinfo", subsystem)
+		}
+	}
+	cgroupPath := path.Join(root, cgroupName)
+	if _, err := os.Stat(cgroupPath); err != nil {
+		return "", err
+	}
+	return cgroupPath, nil
+}
+
+// getUnifiedCgroupPath 返回 cgroup v2 下 cgroupName 的路径，没有则创建，subsystem 有对应的 controller 时确保其在祖先节点中已启用
+func getUnifiedCgroupPath(subsystem, cgroupName string) (string, error) {
+	cgroupPath := path.Join(util.CgroupRootDir, cgroupName)
+	if err := os.MkdirAll(cgroupPath, os.ModePerm); err != nil {
+		logrus.Errorf("failed to mkdir (%v): %v", cgroupPath, err)
+		return "", fmt.Errorf("failed to mkdir (%v): %v", cgroupPath, err)
+	}
+	controller := unifiedController(subsystem)
+	if len(controller) == 0 {
+		return cgroupPath, nil
+	}
+	if err := enableController(controller, cgroupName); err != nil {
+		return "", err
+	}
+	return cgroupPath, nil
+}
+
+// enableController 从根节点开始，依次在 cgroupName 的每一级祖先的 cgroup.subtree_control 中启用 controller，
+// 只有父节点启用了 controller，子节点中才会出现对应的控制文件
+func enableController(controller, cgroupName string) error {
+	current := util.CgroupRootDir
+	for _, elem := range strings.Split(path.Clean(cgroupName), "/") {
+		if len(elem) == 0 {
+			continue
+		}
+		available, err := readCgroupFile(current, "cgroup.controllers")
+		if err != nil {
+			return err
+		}
+		if !containsField(available, controller) {
+			logrus.Errorf("cgroup controller %v is not available in %v: %v", controller, current, available)
+			return fmt.Errorf("cgroup controller %v is not available in %v", controller, current)
+		}
+		var enabled string
+		enabled, err = readCgroupFile(current, "cgroup.subtree_control")
+		if err != nil {
+			return err
+		}
+		if !containsField(enabled, controller) {
+			// systemd 的 slice 和 scope 由 systemd 管理，只能通过 Delegate 让 systemd 启用 controller
+			if isSystemdUnit(cgroupName) {
+				return fmt.Errorf("cgroup controller %v is not delegated to %v by systemd", controller, cgroupName)
+			}
+			if err = writeCgroupFile(current, "cgroup.subtree_control", "+"+controller); err != nil {
+				return fmt.Errorf("failed to enable controller %v in %v: %v", controller, current, err)
+			}
+			logrus.Infof("enabled controller %v in %v", controller, current)
+		}
+		current = path.Join(current, elem)
+	}
+	return nil
+}
+
+// unifiedController 返回 v1 的 subsystem 在 cgroup v2 中对应的 controller 名称
+func unifiedController(subsystem string) string {
+	if name, ok := unifiedControllers[subsystem]; ok {
+		return name
+	}
+	return subsystem
+}
+
+// applyUnified 将 PID 加入 cgroup v2 下的 cgroupName。
+// 与 cgroup v1 中进程会加入每一个层级类似，这里会尽量启用所有 subsystem 对应的 controller，以便统计资源使用情况
+func applyUnified(cgroupName string, pid int) error {
+	cgroupPath, err := getUnifiedCgroupPath("", cgroupName)
+	if err != nil {
+		return err
+	}
+	for _, ss := range Subsystems {
+		controller := unifiedController(ss.Name())
+		if len(controller) == 0 {
+			continue
+		}
+		if err = enableController(controller, cgroupName); err != nil {
+			logrus.Warningf("failed to enable controller of %v for %v: %v", ss.Name(), cgroupName, err)
+		}
+	}
+	if err = writeCgroupFile(cgroupPath, "cgroup.procs", strconv.Itoa(pid)); err != nil {
+		return fmt.Errorf("failed to add pid to %v: %v", cgroupName, err)
+	}
+	return nil
+}
+
+// removeUnified 删除 cgroup v2 下的 cgroupName，cgroup 目录只能用 rmdir 删除
+func removeUnified(cgroupName string) error {
+	cgroupPath := path.Join(util.CgroupRootDir, cgroupName)
+	logrus.Infof("try to remove path of cgroup (%v) is %v", cgroupName, cgroupPath)
+	if err := os.Remove(cgroupPath); err != nil && !os.IsNotExist(err) {
+		logrus.Errorf("failed to remove %v: %v", cgroupPath, err)
+		return err
+	}
+	return nil
+}
+
+// isSystemdUnit 判断 cgroupName 是否是 systemd 驱动下容器的 scope，如：my.slice/my-runc.slice/my-runc-1281457058.scope
+func isSystemdUnit(cgroupName string) bool {
+	return strings.HasSuffix(path.Base(cgroupName), ".scope")
+}
+
+func containsField(s, field string) bool {
+	for _, f := range strings.Fields(s) {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+package command
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/wangao1236/my-runc/pkg/cgroup"
+	"github.com/wangao1236/my-runc/pkg/container"
+	"github.com/wangao1236/my-runc/pkg/spec"
+)
+
+// specNamespaceFlags 是 linux.namespaces 中在 clone 时创建的 namespace，cgroup namespace 由 init 进程创建
+var specNamespaceFlags = map[string]uintptr{
+	spec.PIDNamespace:     syscall.CLONE_NEWPID,
+	spec.NetworkNamespace: syscall.CLONE_NEWNET,
+	spec.MountNamespace:   syscall.CLONE_NEWNS,
+	spec.IPCNamespace:     syscall.CLONE_NEWIPC,
+	spec.UTSNamespace:     syscall.CLONE_NEWUTS,
+	spec.UserNamespace:    syscall.CLONE_NEWUSER,
+}
+
+// applySpec 将 OCI bundle 中的配置映射到 init 进程的配置、容器的 namespace 和 cgroup 资源限制上
+func applySpec(s *spec.Spec, bundle string, initConfig *container.InitConfig, namespaces *container.Namespaces,
+	res *cgroup.ResourceConfig) error {
+	process := s.Process
+	initConfig.Args = process.Args
+	// config.json 中的环境变量是完整的，不继承当前进程的环境变量
+	initConfig.Env = process.Env
+	initConfig.Cwd = process.Cwd
+	initConfig.User = fmt.Sprintf("%v:%v", process.User.UID, process.User.GID)
+	for _, gid := range process.User.AdditionalGids {
+		initConfig.AdditionalGids = append(initConfig.AdditionalGids, int(gid))
+	}
+	initConfig.Hostname = s.Hostname
+	initConfig.ReadonlyRootfs = s.Root.Readonly
+
+	// rlimit 的类型形如 RLIMIT_NOFILE，对应 --ulimit 中的 nofile
+	for _, r := range process.Rlimits {
+		rlimit, err := container.NewRlimit(strings.ToLower(strings.TrimPrefix(r.Type, "RLIMIT_")), r.Soft, r.Hard)
+		if err != nil {
+			return err
+		}
+		initConfig.Rlimits = append(initConfig.Rlimits, rlimit)
+	}
+	initConfig.Mounts = specMounts(s.Mounts, bundle)
+
+	if s.Linux == nil {
+		return nil
+	}
+	if err := specNamespaces(s.Linux, initConfig, namespaces); err != nil {
+		return err
+	}
+	return specResources(s.Linux.Resources, res)
+}
+
+// specMounts 将 mounts 转换为 init 进程中的挂载，bind mount 的相对路径相对于 bundle 目录。
+// my-runc 总是在容器中挂载独立的 devpts 和只读的 cgroupfs，config.json 中对应的挂载会被忽略
+func specMounts(mounts []spec.Mount, bundle string) []*container.Mount {
+	var result []*container.Mount
+	for _, m := range mounts {
+		switch m.Type {
+		case "devpts", "cgroup", "cgroup2":
+			continue
+		}
+		flags, data := spec.ParseMountOptions(m.Options)
+		if m.Type == "bind" {
+			flags |= syscall.MS_BIND
+		}
+		source := m.Source
+		if flags&syscall.MS_BIND != 0 && !path.IsAbs(source) {
+			source = path.Join(bundle, source)
+		}
+		result = append(result, &container.Mount{
+			Source:      source,
+			Destination: m.Destination,
+			Type:        m.Type,
+			Flags:       flags,
+			Data:        data,
+		})
+	}
+	return result
+}
+
+// specNamespaces 根据 linux.namespaces 设置 clone 的标志位以及 user namespace 的 ID 映射，
+// 不支持通过 path 加入已有的 namespace
+func specNamespaces(linux *spec.Linux, initConfig *container.InitConfig, namespaces *container.Namespaces) error {
+	namespaces.Cloneflags = 0
+	initConfig.CgroupNS = container.CgroupNSHost
+	for _, ns := range linux.Namespaces {
+		if len(ns.Path) > 0 {
+			return fmt.Errorf("joining existing %v namespace %v is not supported", ns.Type, ns.Path)
+		}
+		if ns.Type == spec.CgroupNamespace {
+			initConfig.CgroupNS = container.CgroupNSPrivate
+			continue
+		}
+		flag, ok := specNamespaceFlags[ns.Type]
+		if !ok {
+			return fmt.Errorf("unsupported namespace %v", ns.Type)
+		}
+		namespaces.Cloneflags |= flag
+	}
+	// init 进程需要在独立的 mount namespace 中 pivot_root
+	if namespaces.Cloneflags&syscall.CLONE_NEWNS == 0 {
+		return fmt.Errorf("%v namespace is required", spec.MountNamespace)
+	}
+
+	userNS := namespaces.Cloneflags&syscall.CLONE_NEWUSER != 0
+	if userNS != (len(linux.UIDMappings) > 0 && len(linux.GIDMappings) > 0) {
+		return fmt.Errorf("uidMappings and gidMappings must be specified if and only if %v namespace is used",
+			spec.UserNamespace)
+	}
+	// 容器中总会挂载 cgroupfs，而只有在 user namespace 所拥有的 cgroup namespace 中才有权限挂载
+	if userNS && initConfig.CgroupNS != container.CgroupNSPrivate {
+		return fmt.Errorf("%v namespace is required if %v namespace is used", spec.CgroupNamespace, spec.UserNamespace)
+	}
+	namespaces.UIDMappings = specIDMappings(linux.UIDMappings)
+	namespaces.GIDMappings = specIDMappings(linux.GIDMappings)
+	return nil
+}
+
+func specIDMappings(mappings []spec.LinuxIDMapping) []syscall.SysProcIDMap {
+	var result []syscall.SysProcIDMap
+	for _, m := range mappings {
+		result = append(result, syscall.SysProcIDMap{
+			ContainerID: int(m.ContainerID),
+			HostID:      int(m.HostID),
+			Size:        int(m.Size),
+		})
+	}
+	return result
+}
+
+// specResources 将 linux.resources 写入 res，其中 devices 中除了拒绝所有设备的规则之外只支持允许访问的规则，
+// 因为容器的设备规则是默认拒绝所有设备的白名单
+func specResources(resources *spec.LinuxResources, res *cgroup.ResourceConfig) error {
+	if resources == nil {
+		return nil
+	}
+	if memory := resources.Memory; memory != nil {
+		if memory.Limit != nil {
+			res.MemoryLimit = strconv.FormatInt(*memory.Limit, 10)
+		}
+		if memory.Swap != nil {
+			res.MemorySwap = strconv.FormatInt(*memory.Swap, 10)
+		}
+		if memory.DisableOOMKiller != nil {
+			res.OOMKillDisable = *memory.DisableOOMKiller
+		}
+	}
+	if cpu := resources.CPU; cpu != nil {
+		if cpu.Shares != nil {
+			res.CPUShare = strconv.FormatUint(*cpu.Shares, 10)
+		}
+		if cpu.Quota != nil {
+			res.CPUQuota = *cpu.Quota
+		}
+		if cpu.Period != nil {
+			res.CPUPeriod = *cpu.Period
+		}
+		res.CPUSet = cpu.Cpus
+		res.CPUSetMems = cpu.Mems
+	}
+	if resources.Pids != nil {
+		res.PidsLimit = resources.Pids.Limit
+	}
+	if blockIO := resources.BlockIO; blockIO != nil {
+		if blockIO.Weight != nil {
+			res.BlkioWeight = *blockIO.Weight
+		}
+		res.BlkioThrottleReadBpsDevice = specThrottleDevices(blockIO.ThrottleReadBpsDevice)
+		res.BlkioThrottleWriteBpsDevice = specThrottleDevices(blockIO.ThrottleWriteBpsDevice)
+		res.BlkioThrottleReadIOPSDevice = specThrottleDevices(blockIO.ThrottleReadIOPSDevice)
+		res.BlkioThrottleWriteIOPSDevice = specThrottleDevices(blockIO.ThrottleWriteIOPSDevice)
+	}
+	for _, limit := range resources.HugepageLimits {
+		hugepageLimit, err := cgroup.NewHugepageLimit(limit.PageSize, limit.Limit)
+		if err != nil {
+			return fmt.Errorf("invalid hugepage limit %v: %v", limit.PageSize, err)
+		}
+		res.HugetlbLimit = append(res.HugetlbLimit, hugepageLimit)
+	}
+	for _, rule := range resources.Devices {
+		device := &cgroup.Device{
+			Type:        rule.Type,
+			Major:       cgroup.DeviceWildcard,
+			Minor:       cgroup.DeviceWildcard,
+			Permissions: rule.Access,
+		}
+		if len(device.Type) == 0 {
+			device.Type = cgroup.DeviceTypeAll
+		}
+		if len(device.Permissions) == 0 {
+			device.Permissions = "rwm"
+		}
+		if rule.Major != nil {
+			device.Major = *rule.Major
+		}
+		if rule.Minor != nil {
+			device.Minor = *rule.Minor
+		}
+		if !rule.Allow {
+			if device.Type == cgroup.DeviceTypeAll && device.Major == cgroup.DeviceWildcard &&
+				device.Minor == cgroup.DeviceWildcard {
+				continue
+			}
+			return fmt.Errorf("unsupported device rule %+v, only allow rules and the deny-all rule are supported", rule)
+		}
+		res.Devices = append(res.Devices, device)
+	}
+	return nil
+}
+
+func specThrottleDevices(devices []spec.LinuxThrottleDevice) []*cgroup.ThrottleDevice {
+	var result []*cgroup.ThrottleDevice
+	for _, device := range devices {
+		result = append(result, &cgroup.ThrottleDevice{Major: device.Major, Minor: device.Minor, Rate: device.Rate})
+	}
+	return result
+}
@@ -0,0 +1,282 @@
+package command
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wangao1236/my-runc/pkg/cgroup"
+	"github.com/wangao1236/my-runc/pkg/container"
+	"github.com/wangao1236/my-runc/pkg/spec"
+)
+
+func specNamespaceList(types ...string) []spec.LinuxNamespace {
+	var result []spec.LinuxNamespace
+	for _, t := range types {
+		result = append(result, spec.LinuxNamespace{Type: t})
+	}
+	return result
+}
+
+func TestSpecNamespaces(t *testing.T) {
+	mappings := []spec.LinuxIDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}}
+	tests := []struct {
+		name       string
+		linux      *spec.Linux
+		cloneflags uintptr
+		cgroupNS   string
+		hasErr     bool
+	}{
+		{
+			name:       "default namespaces",
+			linux:      &spec.Linux{Namespaces: specNamespaceList(spec.PIDNamespace, spec.MountNamespace, spec.UTSNamespace)},
+			cloneflags: syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWUTS,
+			cgroupNS:   container.CgroupNSHost,
+		},
+		{
+			name:       "cgroup namespace is created by init",
+			linux:      &spec.Linux{Namespaces: specNamespaceList(spec.MountNamespace, spec.CgroupNamespace)},
+			cloneflags: syscall.CLONE_NEWNS,
+			cgroupNS:   container.CgroupNSPrivate,
+		},
+		{
+			name: "join existing namespace",
+			linux: &spec.Linux{Namespaces: []spec.LinuxNamespace{
+				{Type: spec.MountNamespace},
+				{Type: spec.NetworkNamespace, Path: "/proc/1/ns/net"},
+			}},
+			hasErr: true,
+		},
+		{
+			name:   "unknown namespace",
+			linux:  &spec.Linux{Namespaces: specNamespaceList(spec.MountNamespace, "time")},
+			hasErr: true,
+		},
+		{
+			name:   "mount namespace is required",
+			linux:  &spec.Linux{Namespaces: specNamespaceList(spec.PIDNamespace)},
+			hasErr: true,
+		},
+		{
+			name: "user namespace",
+			linux: &spec.Linux{
+				UIDMappings: mappings,
+				GIDMappings: mappings,
+				Namespaces:  specNamespaceList(spec.MountNamespace, spec.UserNamespace, spec.CgroupNamespace),
+			},
+			cloneflags: syscall.CLONE_NEWNS | syscall.CLONE_NEWUSER,
+			cgroupNS:   container.CgroupNSPrivate,
+		},
+		{
+			name: "user namespace without cgroup namespace",
+			linux: &spec.Linux{
+				UIDMappings: mappings,
+				GIDMappings: mappings,
+				Namespaces:  specNamespaceList(spec.MountNamespace, spec.UserNamespace),
+			},
+			hasErr: true,
+		},
+		{
+			name: "user namespace without gid mappings",
+			linux: &spec.Linux{
+				UIDMappings: mappings,
+				Namespaces:  specNamespaceList(spec.MountNamespace, spec.UserNamespace, spec.CgroupNamespace),
+			},
+			hasErr: true,
+		},
+		{
+			name: "mappings without user namespace",
+			linux: &spec.Linux{
+				UIDMappings: mappings,
+				GIDMappings: mappings,
+				Namespaces:  specNamespaceList(spec.MountNamespace),
+			},
+			hasErr: true,
+		},
+	}
+	for _, test := range tests {
+		initConfig := &container.InitConfig{}
+		namespaces := container.DefaultNamespaces()
+		err := specNamespaces(test.linux, initConfig, namespaces)
+		if test.hasErr {
+			assert.NotEqual(t, nil, err, test.name)
+			continue
+		}
+		assert.Equal(t, nil, err, test.name)
+		assert.Equal(t, test.cloneflags, namespaces.Cloneflags, test.name)
+		assert.Equal(t, test.cgroupNS, initConfig.CgroupNS, test.name)
+	}
+}
+
+func TestSpecIDMappings(t *testing.T) {
+	tests := []struct {
+		name     string
+		uidMaps  []spec.LinuxIDMapping
+		gidMaps  []spec.LinuxIDMapping
+		rootUID  int
+		rootGID  int
+		hasErr   bool
+		mappings []syscall.SysProcIDMap
+	}{
+		{
+			name:     "root mapped to the start of the range",
+			uidMaps:  []spec.LinuxIDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}},
+			gidMaps:  []spec.LinuxIDMapping{{ContainerID: 0, HostID: 200000, Size: 65536}},
+			rootUID:  100000,
+			rootGID:  200000,
+			mappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: 100000, Size: 65536}},
+		},
+		{
+			name: "root mapped by the second range",
+			uidMaps: []spec.LinuxIDMapping{
+				{ContainerID: 1000, HostID: 1000, Size: 1},
+				{ContainerID: 0, HostID: 100000, Size: 1000},
+			},
+			gidMaps: []spec.LinuxIDMapping{{ContainerID: 0, HostID: 100000, Size: 1}},
+			rootUID: 100000,
+			rootGID: 100000,
+			mappings: []syscall.SysProcIDMap{
+				{ContainerID: 1000, HostID: 1000, Size: 1},
+				{ContainerID: 0, HostID: 100000, Size: 1000},
+			},
+		},
+		{
+			name:    "uid 0 is not mapped",
+			uidMaps: []spec.LinuxIDMapping{{ContainerID: 1, HostID: 100000, Size: 65536}},
+			gidMaps: []spec.LinuxIDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}},
+			hasErr:  true,
+		},
+		{
+			name:    "gid 0 is not mapped",
+			uidMaps: []spec.LinuxIDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}},
+			gidMaps: []spec.LinuxIDMapping{{ContainerID: 1000, HostID: 1000, Size: 1}},
+			hasErr:  true,
+		},
+	}
+	for _, test := range tests {
+		linux := &spec.Linux{
+			UIDMappings: test.uidMaps,
+			GIDMappings: test.gidMaps,
+			Namespaces:  specNamespaceList(spec.MountNamespace, spec.UserNamespace, spec.CgroupNamespace),
+		}
+		namespaces := container.DefaultNamespaces()
+		assert.Equal(t, nil, specNamespaces(linux, &container.InitConfig{}, namespaces), test.name)
+		uid, gid, err := namespaces.RootIDs()
+		if test.hasErr {
+			assert.NotEqual(t, nil, err, test.name)
+			continue
+		}
+		assert.Equal(t, nil, err, test.name)
+		assert.Equal(t, test.rootUID, uid, test.name)
+		assert.Equal(t, test.rootGID, gid, test.name)
+		assert.Equal(t, test.mappings, namespaces.UIDMappings, test.name)
+	}
+}
+
+func TestSpecResourcesDevices(t *testing.T) {
+	major, minor := int64(10), int64(200)
+	tests := []struct {
+		name    string
+		rules   []spec.LinuxDeviceCgroup
+		devices []*cgroup.Device
+		hasErr  bool
+	}{
+		{
+			name:  "deny all is skipped",
+			rules: []spec.LinuxDeviceCgroup{{Allow: false, Access: "rwm"}},
+		},
+		{
+			name: "allow rule",
+			rules: []spec.LinuxDeviceCgroup{
+				{Allow: false, Access: "rwm"},
+				{Allow: true, Type: cgroup.DeviceTypeChar, Major: &major, Minor: &minor, Access: "rw"},
+			},
+			devices: []*cgroup.Device{
+				{Type: cgroup.DeviceTypeChar, Major: major, Minor: minor, Permissions: "rw"},
+			},
+		},
+		{
+			name:  "allow rule with default type and access",
+			rules: []spec.LinuxDeviceCgroup{{Allow: true, Major: &major}},
+			devices: []*cgroup.Device{
+				{Type: cgroup.DeviceTypeAll, Major: major, Minor: cgroup.DeviceWildcard, Permissions: "rwm"},
+			},
+		},
+		{
+			name:   "deny a single device",
+			rules:  []spec.LinuxDeviceCgroup{{Allow: false, Type: cgroup.DeviceTypeChar, Major: &major, Access: "rwm"}},
+			hasErr: true,
+		},
+		{
+			name:   "deny all char devices",
+			rules:  []spec.LinuxDeviceCgroup{{Allow: false, Type: cgroup.DeviceTypeChar}},
+			hasErr: true,
+		},
+	}
+	for _, test := range tests {
+		res := &cgroup.ResourceConfig{}
+		err := specResources(&spec.LinuxResources{Devices: test.rules}, res)
+		if test.hasErr {
+			assert.NotEqual(t, nil, err, test.name)
+			continue
+		}
+		assert.Equal(t, nil, err, test.name)
+		assert.Equal(t, test.devices, res.Devices, test.name)
+	}
+}
+
+func TestSpecMounts(t *testing.T) {
+	tests := []struct {
+		name   string
+		mount  spec.Mount
+		result *container.Mount
+	}{
+		{
+			name:  "relative bind source",
+			mount: spec.Mount{Destination: "/data", Type: "bind", Source: "data", Options: []string{"ro"}},
+			result: &container.Mount{
+				Source:      "/bundle/data",
+				Destination: "/data",
+				Type:        "bind",
+				Flags:       syscall.MS_BIND | syscall.MS_RDONLY,
+			},
+		},
+		{
+			name:   "relative rbind source without type",
+			mount:  spec.Mount{Destination: "/data", Source: "./data", Options: []string{"rbind"}},
+			result: &container.Mount{Source: "/bundle/data", Destination: "/data", Flags: syscall.MS_BIND | syscall.MS_REC},
+		},
+		{
+			name:   "absolute bind source",
+			mount:  spec.Mount{Destination: "/data", Type: "bind", Source: "/var/data"},
+			result: &container.Mount{Source: "/var/data", Destination: "/data", Type: "bind", Flags: syscall.MS_BIND},
+		},
+		{
+			name:  "relative source of a filesystem",
+			mount: spec.Mount{Destination: "/tmp", Type: "tmpfs", Source: "tmpfs", Options: []string{"nosuid", "size=65536k"}},
+			result: &container.Mount{
+				Source:      "tmpfs",
+				Destination: "/tmp",
+				Type:        "tmpfs",
+				Flags:       syscall.MS_NOSUID,
+				Data:        "size=65536k",
+			},
+		},
+		{
+			name:  "devpts is skipped",
+			mount: spec.Mount{Destination: "/dev/pts", Type: "devpts", Source: "devpts"},
+		},
+		{
+			name:  "cgroup is skipped",
+			mount: spec.Mount{Destination: "/sys/fs/cgroup", Type: "cgroup", Source: "cgroup"},
+		},
+	}
+	for _, test := range tests {
+		result := specMounts([]spec.Mount{test.mount}, "/bundle")
+		if test.result == nil {
+			assert.Equal(t, 0, len(result), test.name)
+			continue
+		}
+		assert.Equal(t, []*container.Mount{test.result}, result, test.name)
+	}
+}
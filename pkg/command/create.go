@@ -0,0 +1,15 @@
+package command
+
+import (
+	"github.com/urfave/cli"
+)
+
+var CreateCommand = cli.Command{
+	Name: "create",
+	Usage: "Create a container without starting it, the init process waits until the start command, " +
+		"my-runc create --name [name] [command]",
+	Flags: createFlags,
+	Action: func(ctx *cli.Context) error {
+		return runContainer(ctx, true)
+	},
+}
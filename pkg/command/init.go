@@ -10,7 +10,7 @@ var InitCommand = cli.Command{
 	Name:  "init",
 	Usage: "Init container process run user's process in container. Do not call it outside",
 
-	// 1. 获取传递过来的 command 参数；
+	// 1. 从管道中读取父进程发送的配置；
 	// 2. 执行容器初始化操作。
 	Action: func(ctx *cli.Context) error {
 		logrus.Infof("init args: %+v", ctx.Args())
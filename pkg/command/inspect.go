@@ -0,0 +1,19 @@
+package command
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli"
+	"github.com/wangao1236/my-runc/pkg/container"
+)
+
+var InspectCommand = cli.Command{
+	Name:  "inspect",
+	Usage: "Display detailed information of the container",
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		return container.InspectContainer(ctx.Args().Get(0))
+	},
+}
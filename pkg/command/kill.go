@@ -0,0 +1,31 @@
+package command
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli"
+	"github.com/wangao1236/my-runc/pkg/container"
+	"github.com/wangao1236/my-runc/pkg/util"
+)
+
+var KillCommand = cli.Command{
+	Name:  "kill",
+	Usage: "Send a signal to the init process of the container",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "signal, s",
+			Value: "SIGKILL",
+			Usage: "Signal to send, e.g. SIGTERM, TERM or 15",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		signal, err := util.ParseSignal(ctx.String("signal"))
+		if err != nil {
+			return err
+		}
+		return container.KillContainer(ctx.Args().Get(0), signal)
+	},
+}
@@ -0,0 +1,30 @@
+package command
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli"
+	"github.com/wangao1236/my-runc/pkg/container"
+)
+
+var PauseCommand = cli.Command{
+	Name:  "pause",
+	Usage: "Suspend all processes in the container",
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		return container.PauseContainer(ctx.Args().Get(0))
+	},
+}
+
+var UnpauseCommand = cli.Command{
+	Name:  "unpause",
+	Usage: "Resume all processes in the paused container",
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		return container.UnpauseContainer(ctx.Args().Get(0))
+	},
+}
@@ -2,10 +2,14 @@ package command
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
@@ -13,193 +17,760 @@ import (
 	"github.com/wangao1236/my-runc/pkg/container"
 	"github.com/wangao1236/my-runc/pkg/layer"
 	"github.com/wangao1236/my-runc/pkg/network"
+	"github.com/wangao1236/my-runc/pkg/spec"
+	"github.com/wangao1236/my-runc/pkg/util"
 )
 
+// resourceFlags 是 run 和 update 命令共用的资源限制参数
+var resourceFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "mem",
+		Usage: "Memory limit",
+	},
+	cli.StringFlag{
+		Name:  "memory-swap",
+		Usage: "Total limit of memory plus swap, must be used with --mem, -1 means unlimited swap",
+	},
+	cli.StringFlag{
+		Name:  "cpu-set",
+		Usage: "CPU set limit",
+	},
+	cli.StringFlag{
+		Name:  "cpuset-mems",
+		Usage: "Memory nodes (MEMs) in which to allow execution, e.g. 0-1",
+	},
+	cli.StringFlag{
+		Name:  "cpu-share",
+		Usage: "CPU share limit",
+	},
+	cli.Int64Flag{
+		Name:  "cpu-quota",
+		Usage: "CPU CFS quota in microseconds per period, -1 means unlimited",
+	},
+	cli.Uint64Flag{
+		Name:  "cpu-period",
+		Usage: "CPU CFS period in microseconds",
+	},
+	cli.Float64Flag{
+		Name:  "cpus",
+		Usage: "Number of CPUs, e.g. 1.5, shortcut of --cpu-quota and --cpu-period",
+	},
+	cli.Int64Flag{
+		Name:  "pids-limit",
+		Usage: "Maximum number of processes in the container, -1 means unlimited",
+	},
+	cli.StringSliceFlag{
+		Name:  "hugetlb-limit",
+		Usage: "Limit usage of hugepages of a page size, e.g. 2MB:1G",
+	},
+}
+
+// createFlags 是 run 和 create 命令共用的参数
+var createFlags = append([]cli.Flag{
+	cli.BoolFlag{
+		Name:  "oom-kill-disable",
+		Usage: "Disable OOM killer, only supported on cgroup v1",
+	},
+	cli.StringSliceFlag{
+		Name:  "device",
+		Usage: "Add a host device to the container, e.g. /dev/sdc:/dev/xvdc:rwm",
+	},
+	cli.UintFlag{
+		Name:  "blkio-weight",
+		Usage: "Block IO relative weight, between 10 and 1000",
+	},
+	cli.StringSliceFlag{
+		Name:  "device-read-bps",
+		Usage: "Limit read rate (bytes per second) from a device, e.g. /dev/sda:1mb",
+	},
+	cli.StringSliceFlag{
+		Name:  "device-write-bps",
+		Usage: "Limit write rate (bytes per second) to a device, e.g. /dev/sda:1mb",
+	},
+	cli.StringSliceFlag{
+		Name:  "device-read-iops",
+		Usage: "Limit read rate (IO per second) from a device, e.g. /dev/sda:1000",
+	},
+	cli.StringSliceFlag{
+		Name:  "device-write-iops",
+		Usage: "Limit write rate (IO per second) to a device, e.g. /dev/sda:1000",
+	},
+	cli.StringFlag{
+		Name: "cgroup-parent",
+		Usage: "Parent cgroup of the container, the container's cgroup is ${cgroup-parent}/${container-id}, " +
+			"it should be a slice name such as my-runc.slice with the systemd cgroup manager",
+	},
+	cli.StringFlag{
+		Name:  "cgroup-manager",
+		Value: cgroup.DriverCgroupfs,
+		Usage: "Cgroup manager, cgroupfs or systemd",
+	},
+	cli.StringFlag{
+		Name:  "cgroupns",
+		Value: container.CgroupNSPrivate,
+		Usage: "Cgroup namespace to use, host or private",
+	},
+	cli.StringFlag{
+		Name:  "stop-signal",
+		Value: "SIGTERM",
+		Usage: "Signal sent to the container by the stop command",
+	},
+	cli.StringFlag{
+		Name:  "restart",
+		Value: container.RestartPolicyNo,
+		Usage: "Restart policy of a detached container, no, on-failure[:max-retries], always or unless-stopped",
+	},
+	cli.StringFlag{
+		Name:  "hostname",
+		Usage: "Hostname of the container",
+	},
+	cli.StringFlag{
+		Name:  "user, u",
+		Usage: "Run the command as uid[:gid] in the container",
+	},
+	cli.StringFlag{
+		Name:  "workdir, w",
+		Usage: "Working directory of the command in the container",
+	},
+	cli.StringSliceFlag{
+		Name:  "ulimit",
+		Usage: "Resource limit of the container process, e.g. nofile=1024:2048",
+	},
+	cli.StringFlag{
+		Name:  "image-tar",
+		Value: "busybox.tar",
+		Usage: "Image tar file name",
+	},
+	cli.StringFlag{
+		Name: "bundle, b",
+		Usage: "Path to an OCI bundle with rootfs and config.json, which replaces --image-tar, " +
+			"other flags override the fields in config.json",
+	},
+	cli.StringSliceFlag{
+		Name:  "v",
+		Usage: "Volume",
+	},
+	cli.StringFlag{
+		Name:  "name",
+		Usage: "Container name",
+	},
+	cli.StringSliceFlag{
+		Name:  "e",
+		Usage: "Environment variables in containers",
+	},
+	cli.StringFlag{
+		Name:  "network",
+		Usage: "Network name used by containers",
+	},
+	cli.StringSliceFlag{
+		Name:  "p",
+		Usage: "Port mapping of containers",
+	},
+}, resourceFlags...)
+
 var RunCommand = cli.Command{
 	Name:  "run",
-	Usage: `Create a container with namespace and cgroups limit my-runc run -ti [command]`,
-	Flags: []cli.Flag{
+	Usage: `Create and start a container with namespace and cgroups limit my-runc run -ti [command]`,
+	Flags: append([]cli.Flag{
 		cli.BoolFlag{
 			Name:  "it",
 			Usage: "Enable tty",
 		},
-		// 增加内存等限制参数
-		cli.StringFlag{
-			Name:  "mem",
-			Usage: "Memory limit",
-		},
-		cli.StringFlag{
-			Name:  "cpu-set",
-			Usage: "CPU set limit",
-		},
-		cli.StringFlag{
-			Name:  "cpu-share",
-			Usage: "CPU share limit",
-		},
-		cli.StringFlag{
-			Name:  "image-tar",
-			Value: "busybox.tar",
-			Usage: "Image tar file name",
-		},
-		cli.StringSliceFlag{
-			Name:  "v",
-			Usage: "Volume",
-		},
 		cli.BoolFlag{
 			Name:  "d",
 			Usage: "Detach container",
 		},
-		cli.StringFlag{
-			Name:  "name",
-			Usage: "Container name",
-		},
-		cli.StringSliceFlag{
-			Name:  "e",
-			Usage: "Environment variables in containers",
-		},
-		cli.StringFlag{
-			Name:  "network",
-			Usage: "Network name used by containers",
-		},
-		cli.StringSliceFlag{
-			Name:  "p",
-			Usage: "Port mapping of containers",
-		},
-	},
+	}, createFlags...),
 
 	// 这里是 run 命令执行的真正函数：
 	// 1. 判断参数是否包含 command；
 	// 2. 获取用户指定的 command；
 	// 3. 调用 Run function 去准备启动容器。
 	Action: func(ctx *cli.Context) error {
-		if len(ctx.Args()) < 1 {
-			return fmt.Errorf("missing container command")
-		}
-		portMappings, err := parsePortMappings(ctx.StringSlice("p"))
-		if err != nil {
-			return fmt.Errorf("invalid port mappings: %v", err)
-		}
-		tty := ctx.Bool("it")
-		detach := ctx.Bool("d")
-		containerName := ctx.String("name")
-		imageTar := ctx.String("image-tar")
-		envs := ctx.StringSlice("e")
-		args := ctx.Args()
-		volumes := ctx.StringSlice("v")
-		networkName := ctx.String("network")
-		logrus.Infof("run args: %+v, container name: %v, enable tty: %v, detach: %v, environment variables: %+v",
-			args, containerName, tty, detach, envs)
-		Run(tty, detach, containerName, imageTar, networkName, envs, args, volumes, portMappings,
-			&cgroup.ResourceConfig{
-				MemoryLimit: ctx.String("mem"),
-				CPUShare:    ctx.String("cpu-share"),
-				CPUSet:      ctx.String("cpu-set"),
-			})
-		return nil
+		return runContainer(ctx, false)
 	},
 }
 
+// runContainer 解析 run 和 create 命令的参数并创建容器，create 为 true 时容器创建之后等待 start。
+// 使用 --bundle 时以 bundle 中的 config.json 为基础，命令行中指定了的参数覆盖其中的配置
+func runContainer(ctx *cli.Context, create bool) error {
+	portMappings, err := parsePortMappings(ctx.StringSlice("p"))
+	if err != nil {
+		return fmt.Errorf("invalid port mappings: %v", err)
+	}
+	// 容器进程默认继承当前进程的环境变量
+	initConfig := &container.InitConfig{
+		Env:      os.Environ(),
+		Mounts:   container.DefaultMounts(),
+		CgroupNS: ctx.String("cgroupns"),
+	}
+	namespaces := container.DefaultNamespaces()
+	res := &cgroup.ResourceConfig{Devices: cgroup.DefaultDevices()}
+	bundle, rootfs, terminal := ctx.String("bundle"), "", false
+	var annotations map[string]string
+	if len(bundle) > 0 {
+		if bundle, err = filepath.Abs(bundle); err != nil {
+			return fmt.Errorf("invalid bundle: %v", err)
+		}
+		var s *spec.Spec
+		if s, err = spec.Load(bundle); err != nil {
+			return err
+		}
+		if err = applySpec(s, bundle, initConfig, namespaces, res); err != nil {
+			return fmt.Errorf("invalid bundle %v: %v", bundle, err)
+		}
+		rootfs, terminal, annotations = s.RootfsPath(bundle), s.Process.Terminal, s.Annotations
+		if ctx.IsSet("cgroupns") {
+			initConfig.CgroupNS = ctx.String("cgroupns")
+		}
+	}
+	if len(ctx.Args()) > 0 {
+		initConfig.Args = ctx.Args()
+	}
+	if len(initConfig.Args) < 1 {
+		return fmt.Errorf("missing container command")
+	}
+	if err = parseResourceConfig(ctx, res); err != nil {
+		return fmt.Errorf("invalid resource limits: %v", err)
+	}
+	var devices []*cgroup.Device
+	if devices, err = parseDevices(ctx.StringSlice("device")); err != nil {
+		return fmt.Errorf("invalid devices: %v", err)
+	}
+	res.Devices = append(res.Devices, devices...)
+	tty, detach := ctx.Bool("it") || terminal, ctx.Bool("d")
+	if terminal && (detach || create) {
+		return fmt.Errorf("process.terminal in config.json can only be used with foreground containers")
+	}
+	// create 创建的容器需要在命令行退出之后等待 start，只能由监控进程创建
+	if create {
+		tty, detach = false, true
+	}
+	containerName := ctx.String("name")
+	imageTar := ctx.String("image-tar")
+	envs := ctx.StringSlice("e")
+	volumes := ctx.StringSlice("v")
+	networkName := ctx.String("network")
+	if len(networkName) > 0 && namespaces.Cloneflags&syscall.CLONE_NEWNET == 0 {
+		return fmt.Errorf("network %v can not be used without a network namespace", networkName)
+	}
+	cgroupParent := ctx.String("cgroup-parent")
+	cgroupDriver := ctx.String("cgroup-manager")
+	if initConfig.CgroupNS != container.CgroupNSHost && initConfig.CgroupNS != container.CgroupNSPrivate {
+		return fmt.Errorf("invalid cgroupns %v, only %v and %v are supported",
+			initConfig.CgroupNS, container.CgroupNSHost, container.CgroupNSPrivate)
+	}
+	stopSignal := ctx.String("stop-signal")
+	if _, err = util.ParseSignal(stopSignal); err != nil {
+		return fmt.Errorf("invalid stop signal: %v", err)
+	}
+	restartPolicy, err := container.ParseRestartPolicy(ctx.String("restart"))
+	if err != nil {
+		return err
+	}
+	if restartPolicy.Name != container.RestartPolicyNo && !detach {
+		return fmt.Errorf("restart policy %v can only be used with detached containers", restartPolicy)
+	}
+	for _, value := range ctx.StringSlice("ulimit") {
+		var rlimit *container.Rlimit
+		if rlimit, err = container.ParseRlimit(value); err != nil {
+			return err
+		}
+		initConfig.Rlimits = append(initConfig.Rlimits, rlimit)
+	}
+	if workdir := ctx.String("workdir"); len(workdir) > 0 {
+		if !path.IsAbs(workdir) {
+			return fmt.Errorf("working directory %v must be absolute", workdir)
+		}
+		initConfig.Cwd = workdir
+	}
+	if ctx.IsSet("user") {
+		initConfig.User, initConfig.AdditionalGids = ctx.String("user"), nil
+	}
+	if ctx.IsSet("hostname") {
+		initConfig.Hostname = ctx.String("hostname")
+	}
+	// -e 指定的环境变量在后面，同名时以后者为准
+	initConfig.Env = append(initConfig.Env, envs...)
+	logrus.Infof("run args: %+v, container name: %v, bundle: %v, enable tty: %v, detach: %v, create only: %v, "+
+		"environment variables: %+v", initConfig.Args, containerName, bundle, tty, detach, create, envs)
+	// 后台运行的容器由监控进程创建，监控进程作为容器 init 进程的父进程，在容器退出时记录退出状态
+	if detach && !container.IsMonitor() {
+		return container.StartMonitor(os.Args[1:])
+	}
+	exitCode, err := Run(&RunOptions{
+		TTY:           tty,
+		Detach:        detach,
+		Create:        create,
+		ContainerName: containerName,
+		ImageTar:      imageTar,
+		Rootfs:        rootfs,
+		Bundle:        bundle,
+		Annotations:   annotations,
+		Volumes:       volumes,
+		NetworkName:   networkName,
+		PortMappings:  portMappings,
+		CgroupDriver:  cgroupDriver,
+		CgroupParent:  cgroupParent,
+		StopSignal:    stopSignal,
+		RestartPolicy: restartPolicy,
+		Namespaces:    namespaces,
+		InitConfig:    initConfig,
+		Resources:     res,
+	})
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return cli.NewExitError("", exitCode)
+	}
+	return nil
+}
+
+// RunOptions 是创建容器的参数
+type RunOptions struct {
+	// TTY 为 true 时容器的标准输入输出连接到当前终端，Detach 为 true 时由监控进程在后台运行容器，
+	// Create 为 true 时只创建容器，等待 start 命令启动
+	TTY           bool
+	Detach        bool
+	Create        bool
+	ContainerName string
+	// Rootfs 不为空时以 OCI bundle 中的 rootfs 作为只读层，否则解压 ImageTar 作为只读层
+	ImageTar string
+	Rootfs   string
+	// Bundle 和 Annotations 是 OCI bundle 的目录和其中的 annotations，记录在元数据中供 state 命令输出
+	Bundle        string
+	Annotations   map[string]string
+	Volumes       []string
+	NetworkName   string
+	PortMappings  map[int]int
+	CgroupDriver  string
+	CgroupParent  string
+	StopSignal    string
+	RestartPolicy *container.RestartPolicy
+	Namespaces    *container.Namespaces
+	InitConfig    *container.InitConfig
+	Resources     *cgroup.ResourceConfig
+}
+
 // Run fork 出当前进程，执行 init 命令。
 // 它首先会 clone 出来一批 namespace 隔离的进程，然后在子进程中，调用 /proc/self/exe，也就是自己调用自己。
-// 发送 init 参数，调用我们写的 init 方法，去初始化容器的一些资源
-func Run(tty, detach bool, containerName string, imageTar, networkName string,
-	envs, args, volumes []string, portMappings map[int]int, res *cgroup.ResourceConfig) {
+// 发送 init 参数，调用我们写的 init 方法，去初始化容器的一些资源。
+// 容器先被创建（created），init 进程在 exec fifo 上等待，再由 start 使其执行用户命令。
+// 前台运行时返回容器的退出码，被信号杀死时为 128 + 信号值。
+// 创建容器的任何一步失败时（包括 init 进程初始化失败），都会回滚已经创建的 workspace、cgroup、网络和元数据
+func Run(opts *RunOptions) (exitCode int, err error) {
+	// 前台运行的容器退出后需要清理资源，后台运行的容器只在启动失败时清理，否则由 rm 命令清理
+	cleanup := func() bool {
+		return !opts.Detach || err != nil
+	}
+
+	// 每个容器使用单独的 cgroup，避免容器之间的资源限制相互覆盖
+	containerID := container.GenerateContainerID()
+	cgroupPath, err := container.GenerateCgroupPath(opts.CgroupDriver, opts.CgroupParent, containerID)
+	if err != nil {
+		logrus.Errorf("failed to generate cgroup path: %v", err)
+		return -1, err
+	}
+
+	rootUID, rootGID, err := opts.Namespaces.RootIDs()
+	if err != nil {
+		logrus.Errorf("failed to get root of user namespace: %v", err)
+		return -1, err
+	}
 	rootDir, err := os.Getwd()
 	if err != nil {
-		logrus.Fatalf("failed to get current directory: %v", err)
+		logrus.Errorf("failed to get current directory: %v", err)
+		return -1, err
 	}
 	var writeLayer, workLayer, workspace string
-	writeLayer, workLayer, workspace, err = layer.CreateWorkspace(rootDir, imageTar, containerName, volumes)
+	if len(opts.Rootfs) > 0 {
+		writeLayer, workLayer, workspace, err = layer.CreateWorkspaceFromRootfs(rootDir, opts.Rootfs, opts.ContainerName,
+			opts.Volumes)
+	} else {
+		writeLayer, workLayer, workspace, err = layer.CreateWorkspace(rootDir, opts.ImageTar, opts.ContainerName,
+			opts.Volumes)
+	}
 	if err != nil {
-		logrus.Fatalf("failed to create workspace: %v", err)
+		logrus.Errorf("failed to create workspace: %v", err)
+		return -1, fmt.Errorf("failed to create workspace: %v", err)
 	}
 	defer func() {
-		if !detach && err == nil {
-			layer.DeleteWorkspace(workspace, workLayer, writeLayer, volumes)
+		if cleanup() {
+			layer.DeleteWorkspace(workspace, workLayer, writeLayer, opts.Volumes)
 		}
 	}()
+	// 容器内的 root 需要在根目录下创建挂载点，rootfs 中的其他文件的属主由用户负责映射
+	if err = os.Chown(workspace, rootUID, rootGID); err != nil {
+		logrus.Errorf("failed to chown workspace %v: %v", workspace, err)
+		return -1, err
+	}
 
-	cgroupManager := cgroup.NewManager("my-runc-cgroup")
+	cgroupManager := container.NewCgroupManager(opts.CgroupDriver, cgroupPath)
 	defer func() {
-		if !detach && err == nil {
-			if err = cgroupManager.Destroy(); err != nil {
-				logrus.Warningf("failed to destroy cgroups: %v", err)
+		if cleanup() {
+			if destroyErr := cgroupManager.Destroy(); destroyErr != nil {
+				logrus.Warningf("failed to destroy cgroups: %v", destroyErr)
 			} else {
 				logrus.Info("destroy cgroups successfully")
 			}
 		}
 	}()
-	if err = cgroupManager.Set(res); err != nil {
-		logrus.Fatalf("failed to set resource config to cgroups for parent process: %v", err)
+	if err = cgroupManager.Set(opts.Resources); err != nil {
+		logrus.Errorf("failed to set resource config to cgroups for parent process: %v", err)
+		return -1, fmt.Errorf("failed to set resource limits: %v", err)
 	}
-	logrus.Infof("set resource (%+v) to cgroups for parent process", res)
+	logrus.Infof("set resource (%+v) to cgroups for parent process", opts.Resources)
 
 	var parent *exec.Cmd
-	var writePipe *os.File
-	parent, writePipe, err = container.NewParentProcess(tty, workspace, containerName, envs)
+	var initPipe *container.InitPipe
+	parent, initPipe, err = container.NewParentProcess(opts.TTY, workspace, opts.ContainerName, opts.Namespaces)
 	if err != nil {
-		logrus.Fatalf("failed to build parent process: %v", err)
+		logrus.Errorf("failed to build parent process: %v", err)
+		return -1, err
 	}
 	logrus.Infof("parent process command: %v", parent.String())
 	if err = parent.Start(); err != nil {
-		logrus.Fatalf("parent process failed to start: %v", err)
+		logrus.Errorf("parent process failed to start: %v", err)
+		initPipe.Close()
+		return -1, err
 	}
+	// 启动失败时需要先杀死 init 进程，cgroup 中没有进程之后才能删除
+	defer func() {
+		if err != nil {
+			initPipe.Close()
+			_ = parent.Process.Kill()
+			_ = parent.Wait()
+		}
+	}()
 
-	if err = container.CreateMetadata(parent.Process.Pid, args, containerName, volumes); err != nil {
-		logrus.Fatalf("failed to record metadata of container (%v): %v", containerName, err)
+	if err = container.CreateMetadata(&container.Metadata{
+		ID:            containerID,
+		Name:          opts.ContainerName,
+		PID:           parent.Process.Pid,
+		Command:       strings.Join(opts.InitConfig.Args, " "),
+		Volumes:       opts.Volumes,
+		CgroupPath:    cgroupPath,
+		CgroupDriver:  opts.CgroupDriver,
+		Bundle:        opts.Bundle,
+		Annotations:   opts.Annotations,
+		Resources:     opts.Resources,
+		StopSignal:    opts.StopSignal,
+		RestartPolicy: opts.RestartPolicy,
+	}); err != nil {
+		logrus.Errorf("failed to record metadata of container (%v): %v", opts.ContainerName, err)
+		return -1, err
 	}
 	defer func() {
-		if !detach && err == nil {
-			if err = container.RemoveMetadata(containerName); err != nil {
-				logrus.Warningf("failed to remove metadata of container (%v): %v", containerName, err)
+		if cleanup() {
+			if removeErr := container.RemoveMetadata(opts.ContainerName); removeErr != nil {
+				logrus.Warningf("failed to remove metadata of container (%v): %v", opts.ContainerName, removeErr)
 			}
 		}
 	}()
 
 	if err = cgroupManager.Apply(parent.Process.Pid); err != nil {
-		logrus.Fatalf("failed to apply pid (%v) of parent process to cgroups; %v", parent.Process.Pid, err)
+		logrus.Errorf("failed to apply pid (%v) of parent process to cgroups; %v", parent.Process.Pid, err)
+		return -1, fmt.Errorf("failed to apply cgroups: %v", err)
 	}
 	logrus.Infof("applied pid (%v) of parent process to cgroups successfully", parent.Process.Pid)
+	if watchErr := container.WatchOOM(opts.ContainerName); watchErr != nil {
+		logrus.Warningf("failed to watch oom events of container %v: %v", opts.ContainerName, watchErr)
+	}
 
-	if len(networkName) > 0 {
+	if len(opts.NetworkName) > 0 {
 		var metadata *container.Metadata
-		metadata, err = container.ReadMetadata(containerName)
+		metadata, err = container.ReadMetadata(opts.ContainerName)
 		if err != nil {
-			logrus.Fatalf("failed to get metadata of container (%v) for setting contaienr network: %v",
-				containerName, err)
-			return
+			logrus.Errorf("failed to get metadata of container (%v) for setting contaienr network: %v",
+				opts.ContainerName, err)
+			return -1, err
 		}
-		if err = network.Connect(networkName, portMappings, metadata); err != nil {
-			logrus.Fatalf("failed to connect network (%v) for container (%v): %v", networkName, metadata, err)
+		if err = network.Connect(opts.NetworkName, opts.PortMappings, metadata); err != nil {
+			logrus.Errorf("failed to connect network (%v) for container (%v): %v", opts.NetworkName, metadata, err)
+			return -1, fmt.Errorf("failed to connect network %v: %v", opts.NetworkName, err)
 		}
-		logrus.Infof("succeeded in connecting network (%v) for container (%v)", networkName, metadata.Name)
+		logrus.Infof("succeeded in connecting network (%v) for container (%v)", opts.NetworkName, metadata.Name)
 		defer func() {
-			if !detach {
-				if err = network.Disconnect(metadata); err != nil {
-					logrus.Warningf("failed to disconnect network for container (%v): %v", metadata, err)
+			if cleanup() {
+				if disconnectErr := network.Disconnect(metadata); disconnectErr != nil {
+					logrus.Warningf("failed to disconnect network for container (%v): %v", metadata, disconnectErr)
 				}
 			}
 		}()
 	}
 
-	sendInitArgs(args, writePipe)
+	// 等待 init 进程完成挂载等初始化工作，此时容器处于 created 状态
+	opts.InitConfig.Devices = opts.Resources.Devices
+	if err = initPipe.Send(opts.InitConfig); err != nil {
+		logrus.Errorf("failed to init container %v: %v", opts.ContainerName, err)
+		return -1, fmt.Errorf("failed to init container %v: %v", opts.ContainerName, err)
+	}
+	logrus.Infof("container %v has been created", opts.ContainerName)
+
+	// 后台运行时当前进程是监控进程，需要一直等待容器退出
+	restart := func(metadata *container.Metadata) (*exec.Cmd, error) {
+		return restartContainerProcess(opts.TTY, workspace, opts.Namespaces, opts.InitConfig, cgroupManager, metadata)
+	}
+	if opts.Create {
+		container.Monitor(opts.ContainerName, parent, initPipe, restart)
+		return 0, nil
+	}
+	// run 相当于 create 之后立即 start
+	if err = container.StartContainer(opts.ContainerName); err == nil {
+		err = initPipe.WaitExec()
+	}
+	if err != nil {
+		logrus.Errorf("failed to start container %v: %v", opts.ContainerName, err)
+		return -1, fmt.Errorf("failed to start container %v: %v", opts.ContainerName, err)
+	}
+
+	logrus.Infof("parent process started successfully, detach: %v", opts.Detach)
+	if opts.Detach {
+		container.Monitor(opts.ContainerName, parent, nil, restart)
+		return 0, nil
+	}
+	// 前台运行时将收到的信号转发给容器，容器以非 0 退出码退出不影响清理资源
+	stopForwarding := container.ForwardSignals(parent.Process.Pid)
+	if waitErr := parent.Wait(); waitErr != nil {
+		logrus.Infof("parent process exited: %v", waitErr)
+	}
+	stopForwarding()
+	logrus.Info("parent process stopped")
+	if stats, statsErr := cgroupManager.GetStats(); statsErr == nil && stats.Memory.OOMKills > 0 {
+		logrus.Warningf("%v processes of container %v were killed by the oom killer",
+			stats.Memory.OOMKills, opts.ContainerName)
+	}
+	if parent.ProcessState == nil {
+		return -1, nil
+	}
+	return container.ExitCode(parent.ProcessState), nil
+}
+
+// restartContainerProcess 在监控进程中重新创建容器的 init 进程，复用原有的 workspace、cgroup 和网络 endpoint，
+// 新进程的 PID 和重新创建的 endpoint 记录在 metadata 中，由调用者保存
+func restartContainerProcess(tty bool, workspace string, namespaces *container.Namespaces,
+	initConfig *container.InitConfig, cgroupManager *cgroup.Manager, metadata *container.Metadata) (*exec.Cmd, error) {
+	// 容器的资源限制可能已经被 update 命令修改过，以元数据中的为准
+	res := metadata.Resources
+	if res == nil {
+		res = &cgroup.ResourceConfig{}
+	}
+	cgroupManager.Resource = res
+	parent, initPipe, err := container.NewParentProcess(tty, workspace, metadata.Name, namespaces)
+	if err != nil {
+		logrus.Errorf("failed to build parent process: %v", err)
+		return nil, err
+	}
+	if err = parent.Start(); err != nil {
+		logrus.Errorf("parent process failed to start: %v", err)
+		initPipe.Close()
+		return nil, err
+	}
+	// 监控进程可能会多次重启容器，子进程已经继承的日志文件需要关闭，避免文件描述符泄漏
+	if logFile, ok := parent.Stdout.(*os.File); ok && !tty {
+		_ = logFile.Close()
+	}
+	metadata.PID = parent.Process.Pid
+	// 启动失败时 init 进程还在等待参数，直接杀死即可
+	abort := func(err error) (*exec.Cmd, error) {
+		initPipe.Close()
+		_ = parent.Process.Kill()
+		_ = parent.Wait()
+		return nil, err
+	}
+	if err = cgroupManager.Apply(metadata.PID); err != nil {
+		logrus.Errorf("failed to apply pid (%v) of parent process to cgroups: %v", metadata.PID, err)
+		return abort(err)
+	}
+	// cgroup v1 的 eventfd 在 cgroup 删除之前一直有效，cgroup v2 的监听在 cgroup 中没有进程之后就会退出
+	if util.IsCgroup2UnifiedMode() {
+		if watchErr := container.WatchOOM(metadata.Name); watchErr != nil {
+			logrus.Warningf("failed to watch oom events of container %v: %v", metadata.Name, watchErr)
+		}
+	}
+	if err = network.Reconnect(metadata); err != nil {
+		logrus.Errorf("failed to reconnect network for container (%v): %v", metadata.Name, err)
+		return abort(err)
+	}
+	config := *initConfig
+	config.Devices = res.Devices
+	if err = initPipe.Send(&config); err != nil {
+		logrus.Errorf("failed to init container %v: %v", metadata.Name, err)
+		return abort(err)
+	}
+	// 重启的容器不经过 created 状态，直接 start
+	if err = initPipe.Start(metadata.PID); err == nil {
+		err = initPipe.WaitExec()
+	}
+	if err != nil {
+		logrus.Errorf("failed to start container %v: %v", metadata.Name, err)
+		return abort(err)
+	}
+	return parent, nil
+}
+
+// parseResourceConfig 将命令行中指定了的资源限制参数写入 res，未指定的参数保持 res 中原有的值，
+// 其中 --cpus 会被换算为 cpu quota 和 cpu period
+func parseResourceConfig(ctx *cli.Context, res *cgroup.ResourceConfig) error {
+	if ctx.IsSet("mem") {
+		res.MemoryLimit = ctx.String("mem")
+	}
+	if ctx.IsSet("memory-swap") {
+		res.MemorySwap = ctx.String("memory-swap")
+	}
+	if ctx.IsSet("oom-kill-disable") {
+		res.OOMKillDisable = ctx.Bool("oom-kill-disable")
+	}
+	if ctx.IsSet("cpu-share") {
+		res.CPUShare = ctx.String("cpu-share")
+	}
+	if ctx.IsSet("cpu-set") {
+		res.CPUSet = ctx.String("cpu-set")
+	}
+	if ctx.IsSet("cpuset-mems") {
+		res.CPUSetMems = ctx.String("cpuset-mems")
+	}
+	if ctx.IsSet("cpu-quota") {
+		res.CPUQuota = ctx.Int64("cpu-quota")
+	}
+	if ctx.IsSet("cpu-period") {
+		res.CPUPeriod = ctx.Uint64("cpu-period")
+	}
+	if ctx.IsSet("pids-limit") {
+		res.PidsLimit = ctx.Int64("pids-limit")
+	}
+	var err error
+	if ctx.IsSet("cpus") {
+		if ctx.IsSet("cpu-quota") {
+			return fmt.Errorf("--cpus and --cpu-quota can not be used together")
+		}
+		res.CPUQuota, res.CPUPeriod, err = cgroup.CPUsToQuota(ctx.Float64("cpus"), res.CPUPeriod)
+		if err != nil {
+			return err
+		}
+	}
+	if ctx.IsSet("blkio-weight") {
+		weight := ctx.Uint("blkio-weight")
+		if weight > math.MaxUint16 {
+			return fmt.Errorf("invalid blkio weight: %v", weight)
+		}
+		res.BlkioWeight = uint16(weight)
+	}
+	throttles := []struct {
+		flag      string
+		devices   *[]*cgroup.ThrottleDevice
+		parseRate func(string) (uint64, error)
+	}{
+		{"device-read-bps", &res.BlkioThrottleReadBpsDevice, parseBpsRate},
+		{"device-write-bps", &res.BlkioThrottleWriteBpsDevice, parseBpsRate},
+		{"device-read-iops", &res.BlkioThrottleReadIOPSDevice, parseIOPSRate},
+		{"device-write-iops", &res.BlkioThrottleWriteIOPSDevice, parseIOPSRate},
+	}
+	for _, throttle := range throttles {
+		if !ctx.IsSet(throttle.flag) {
+			continue
+		}
+		if *throttle.devices, err = parseThrottleDevices(ctx.StringSlice(throttle.flag), throttle.parseRate); err != nil {
+			return err
+		}
+	}
+	if ctx.IsSet("hugetlb-limit") {
+		if res.HugetlbLimit, err = parseHugetlbLimits(ctx.StringSlice("hugetlb-limit")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseHugetlbLimits 解析 ${page-size}:${limit} 形式的大页限制参数，如 2MB:1G
+func parseHugetlbLimits(values []string) ([]*cgroup.HugepageLimit, error) {
+	var limits []*cgroup.HugepageLimit
+	for _, value := range values {
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid hugetlb limit %v, expect ${page-size}:${limit}", value)
+		}
+		limit, err := util.ParseBytes(parts[1])
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid limit in hugetlb limit %v", value)
+		}
+		var hugepageLimit *cgroup.HugepageLimit
+		if hugepageLimit, err = cgroup.NewHugepageLimit(parts[0], uint64(limit)); err != nil {
+			return nil, fmt.Errorf("invalid hugetlb limit %v: %v", value, err)
+		}
+		limits = append(limits, hugepageLimit)
+	}
+	return limits, nil
+}
 
-	logrus.Infof("parent process started successfully, detach: %v", detach)
-	if !detach {
-		if err = parent.Wait(); err != nil {
-			logrus.Errorf("failed to wait parent process stopping: %v", err)
+// parseDevices 解析 ${host-path}[:${container-path}][:${permissions}] 形式的设备参数，
+// 容器内路径默认与宿主机相同，权限默认为 rwm
+func parseDevices(values []string) ([]*cgroup.Device, error) {
+	var devices []*cgroup.Device
+	for _, value := range values {
+		parts := strings.Split(value, ":")
+		if len(parts) > 3 || len(parts[0]) == 0 {
+			return nil, fmt.Errorf("invalid device %v, expect ${host-path}[:${container-path}][:${permissions}]", value)
+		}
+		hostPath, containerPath, permissions := parts[0], parts[0], "rwm"
+		switch len(parts) {
+		case 2:
+			// 第二段不是路径时表示权限，如 /dev/sdc:r
+			if strings.HasPrefix(parts[1], "/") {
+				containerPath = parts[1]
+			} else {
+				permissions = parts[1]
+			}
+		case 3:
+			containerPath, permissions = parts[1], parts[2]
+		}
+		if !path.IsAbs(containerPath) {
+			return nil, fmt.Errorf("container path of device %v must be absolute", value)
+		}
+		device, err := cgroup.NewDevice(hostPath, path.Clean(containerPath), permissions)
+		if err != nil {
+			return nil, fmt.Errorf("invalid device %v: %v", value, err)
 		}
-		logrus.Info("parent process stopped")
+		devices = append(devices, device)
 	}
+	return devices, nil
 }
 
-func sendInitArgs(args []string, writePipe *os.File) {
-	if _, err := writePipe.WriteString(strings.Join(args, " ")); err != nil {
-		logrus.Fatalf("write string to pipe for args (%+v) failed: %v", args, err)
+// parseThrottleDevices 解析 ${device-path}:${rate} 形式的块设备限速参数
+func parseThrottleDevices(values []string, parseRate func(string) (uint64, error)) ([]*cgroup.ThrottleDevice, error) {
+	var devices []*cgroup.ThrottleDevice
+	for _, value := range values {
+		idx := strings.LastIndex(value, ":")
+		if idx <= 0 {
+			return nil, fmt.Errorf("invalid device throttle %v, expect ${device-path}:${rate}", value)
+		}
+		rate, err := parseRate(value[idx+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate in device throttle %v: %v", value, err)
+		}
+		var device *cgroup.ThrottleDevice
+		if device, err = cgroup.NewThrottleDevice(value[:idx], rate); err != nil {
+			return nil, fmt.Errorf("invalid device in device throttle %v: %v", value, err)
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+func parseBpsRate(rate string) (uint64, error) {
+	bytes, err := util.ParseBytes(rate)
+	if err != nil {
+		return 0, err
 	}
-	if err := writePipe.Close(); err != nil {
-		logrus.Errorf("failed to close write pipe: %v", err)
+	if bytes < 0 {
+		return 0, fmt.Errorf("rate must not be negative")
 	}
+	return uint64(bytes), nil
+}
+
+func parseIOPSRate(rate string) (uint64, error) {
+	return strconv.ParseUint(rate, 10, 64)
 }
 
 func parsePortMappings(portMappings []string) (map[int]int, error) {
@@ -0,0 +1,101 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wangao1236/my-runc/pkg/cgroup"
+	"golang.org/x/sys/unix"
+)
+
+// findBlockDevice 返回 /dev 下的第一个块设备及其设备号，没有块设备时跳过测试
+func findBlockDevice(t *testing.T) (string, string) {
+	files, err := ioutil.ReadDir("/dev")
+	assert.Equal(t, nil, err)
+	for _, file := range files {
+		if file.Mode()&os.ModeDevice == 0 || file.Mode()&os.ModeCharDevice != 0 {
+			continue
+		}
+		devicePath := path.Join("/dev", file.Name())
+		var st unix.Stat_t
+		if err = unix.Stat(devicePath, &st); err != nil {
+			continue
+		}
+		return devicePath, fmt.Sprintf("%v:%v", unix.Major(st.Rdev), unix.Minor(st.Rdev))
+	}
+	t.Skip("no block device is available")
+	return "", ""
+}
+
+func TestParseThrottleDevices(t *testing.T) {
+	device, deviceID := findBlockDevice(t)
+	tests := []struct {
+		name      string
+		value     string
+		parseRate func(string) (uint64, error)
+		result    string
+		hasErr    bool
+	}{
+		{name: "bps with unit", value: device + ":1mb", parseRate: parseBpsRate, result: deviceID + " 1048576"},
+		{name: "bps in bytes", value: device + ":4096", parseRate: parseBpsRate, result: deviceID + " 4096"},
+		{name: "iops", value: device + ":100", parseRate: parseIOPSRate, result: deviceID + " 100"},
+		{name: "iops with unit", value: device + ":1k", parseRate: parseIOPSRate, hasErr: true},
+		{name: "negative bps", value: device + ":-1", parseRate: parseBpsRate, hasErr: true},
+		{name: "missing rate", value: device, parseRate: parseBpsRate, hasErr: true},
+		{name: "missing device", value: ":100", parseRate: parseIOPSRate, hasErr: true},
+		{name: "char device", value: "/dev/null:100", parseRate: parseIOPSRate, hasErr: true},
+		{name: "nonexistent device", value: "/dev/nonexistent:100", parseRate: parseIOPSRate, hasErr: true},
+	}
+	for _, test := range tests {
+		devices, err := parseThrottleDevices([]string{test.value}, test.parseRate)
+		if test.hasErr {
+			assert.NotEqual(t, nil, err, test.name)
+			continue
+		}
+		assert.Equal(t, nil, err, test.name)
+		assert.Equal(t, 1, len(devices), test.name)
+		assert.Equal(t, test.result, devices[0].String(), test.name)
+	}
+}
+
+func TestParseHugetlbLimits(t *testing.T) {
+	sizes, err := cgroup.GetHugePageSizes()
+	assert.Equal(t, nil, err)
+	if len(sizes) == 0 {
+		t.Skip("hugepages are not supported by the host")
+	}
+	pageSize := sizes[0]
+	tests := []struct {
+		name   string
+		value  string
+		limit  *cgroup.HugepageLimit
+		hasErr bool
+	}{
+		{name: "limit with unit", value: pageSize + ":1G", limit: &cgroup.HugepageLimit{PageSize: pageSize, Limit: 1 << 30}},
+		{name: "limit in bytes", value: pageSize + ":0", limit: &cgroup.HugepageLimit{PageSize: pageSize, Limit: 0}},
+		{
+			name:  "lower case page size",
+			value: strings.ToLower(pageSize) + ":100m",
+			limit: &cgroup.HugepageLimit{PageSize: pageSize, Limit: 100 << 20},
+		},
+		{name: "missing limit", value: pageSize, hasErr: true},
+		{name: "negative limit", value: pageSize + ":-1", hasErr: true},
+		{name: "invalid limit", value: pageSize + ":abc", hasErr: true},
+		{name: "invalid page size", value: "abc:1G", hasErr: true},
+		{name: "unsupported page size", value: "3MB:1G", hasErr: true},
+	}
+	for _, test := range tests {
+		limits, err := parseHugetlbLimits([]string{test.value})
+		if test.hasErr {
+			assert.NotEqual(t, nil, err, test.name)
+			continue
+		}
+		assert.Equal(t, nil, err, test.name)
+		assert.Equal(t, []*cgroup.HugepageLimit{test.limit}, limits, test.name)
+	}
+}
@@ -0,0 +1,42 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"github.com/wangao1236/my-runc/pkg/spec"
+)
+
+var SpecCommand = cli.Command{
+	Name:  "spec",
+	Usage: "Create a default config.json of an OCI bundle, the rootfs is expected in ${bundle}/rootfs",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "bundle, b",
+			Value: ".",
+			Usage: "Path to the bundle directory",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		configPath := path.Join(ctx.String("bundle"), spec.ConfigName)
+		if _, err := os.Stat(configPath); err == nil {
+			return fmt.Errorf("%v already exists, remove it first", configPath)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		body, err := json.MarshalIndent(spec.Example(), "", "    ")
+		if err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(configPath, body, 0644); err != nil {
+			logrus.Errorf("failed to write %v: %v", configPath, err)
+			return err
+		}
+		return nil
+	},
+}
@@ -0,0 +1,19 @@
+package command
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli"
+	"github.com/wangao1236/my-runc/pkg/container"
+)
+
+var StartCommand = cli.Command{
+	Name:  "start",
+	Usage: "Start a created container, the init process executes the user command",
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		return container.StartContainer(ctx.Args().Get(0))
+	},
+}
@@ -0,0 +1,19 @@
+package command
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli"
+	"github.com/wangao1236/my-runc/pkg/container"
+)
+
+var StateCommand = cli.Command{
+	Name:  "state",
+	Usage: "Output the state of a container in the format of the OCI runtime-spec",
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		return container.StateContainer(ctx.Args().Get(0))
+	},
+}
@@ -0,0 +1,30 @@
+package command
+
+import (
+	"github.com/urfave/cli"
+	"github.com/wangao1236/my-runc/pkg/container"
+)
+
+var StatsCommand = cli.Command{
+	Name:      "stats",
+	Usage:     "Display a live stream of resource usage of containers, all running containers by default",
+	ArgsUsage: "[CONTAINER...]",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "no-stream",
+			Usage: "print the first result only instead of streaming",
+		},
+		cli.BoolFlag{
+			Name:  "psi",
+			Usage: "show pressure stall information and memory events instead of resource usage, cgroup v2 only",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Value: container.StatsFormatTable,
+			Usage: "output format, table or json",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		return container.StatsContainers(ctx.Args(), ctx.Bool("no-stream"), ctx.Bool("psi"), ctx.String("format"))
+	},
+}
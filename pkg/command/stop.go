@@ -2,6 +2,7 @@ package command
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/urfave/cli"
 	"github.com/wangao1236/my-runc/pkg/container"
@@ -9,11 +10,22 @@ import (
 
 var StopCommand = cli.Command{
 	Name:  "stop",
-	Usage: "Stop the container",
+	Usage: "Stop the container, send SIGKILL if it does not exit within the grace period",
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "time, t",
+			Value: int(container.DefaultStopTimeout / time.Second),
+			Usage: "Seconds to wait for the container to exit before killing it",
+		},
+	},
 	Action: func(ctx *cli.Context) error {
 		if len(ctx.Args()) < 1 {
 			return fmt.Errorf("missing container name")
 		}
-		return container.StopContainer(ctx.Args().Get(0))
+		timeout := ctx.Int("time")
+		if timeout < 0 {
+			return fmt.Errorf("invalid time %v, it should not be negative", timeout)
+		}
+		return container.StopContainer(ctx.Args().Get(0), time.Duration(timeout)*time.Second)
 	},
 }
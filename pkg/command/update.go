@@ -0,0 +1,37 @@
+package command
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"github.com/wangao1236/my-runc/pkg/cgroup"
+	"github.com/wangao1236/my-runc/pkg/container"
+)
+
+var UpdateCommand = cli.Command{
+	Name:  "update",
+	Usage: "Update resource limits of a running container, e.g. my-runc update --mem 200m --cpus 2 [container]",
+	Flags: resourceFlags,
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		containerName := ctx.Args().Get(0)
+		metadata, err := container.ReadMetadata(containerName)
+		if err != nil {
+			logrus.Errorf("failed to read metadata of %v: %v", containerName, err)
+			return err
+		}
+
+		// 在原有限制的基础上修改，未指定的参数保持不变
+		res := &cgroup.ResourceConfig{}
+		if metadata.Resources != nil {
+			*res = *metadata.Resources
+		}
+		if err = parseResourceConfig(ctx, res); err != nil {
+			return fmt.Errorf("invalid resource limits: %v", err)
+		}
+		return container.UpdateContainer(metadata, res)
+	},
+}
@@ -0,0 +1,32 @@
+package command
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli"
+	"github.com/wangao1236/my-runc/pkg/container"
+)
+
+var WaitCommand = cli.Command{
+	Name:      "wait",
+	Usage:     "Block until containers exit, then print their exit codes",
+	ArgsUsage: "CONTAINER [CONTAINER...]",
+	// 依次等待每个容器退出并输出退出码，命令的退出码与最后一个容器的退出码相同
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		var exitCode int
+		for _, containerName := range ctx.Args() {
+			var err error
+			if exitCode, err = container.WaitContainer(containerName); err != nil {
+				return err
+			}
+			fmt.Println(exitCode)
+		}
+		if exitCode != 0 {
+			return cli.NewExitError("", exitCode)
+		}
+		return nil
+	},
+}
@@ -1,34 +1,100 @@
 package container
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
+	"runtime"
 	"sort"
 	"strings"
 	"syscall"
 	"text/tabwriter"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/wangao1236/my-runc/pkg/cgroup"
 	"github.com/wangao1236/my-runc/pkg/layer"
 	"github.com/wangao1236/my-runc/pkg/util"
+	"golang.org/x/sys/unix"
 )
 
+const (
+	// CgroupNSPrivate 表示容器使用独立的 cgroup namespace，容器内看到的 cgroup 根目录即为容器自己的 cgroup
+	CgroupNSPrivate = "private"
+	// CgroupNSHost 表示容器与宿主机共用 cgroup namespace
+	CgroupNSHost = "host"
+
+	// oldRootDir 是 pivot_root 之后宿主机根目录的挂载点，在容器内的挂载完成之后卸载
+	oldRootDir = "/.pivot_root"
+)
+
+// Namespaces 是容器 init 进程在 clone 时创建的 namespace，以及创建 user namespace 时的 ID 映射
+type Namespaces struct {
+	Cloneflags  uintptr
+	UIDMappings []syscall.SysProcIDMap
+	GIDMappings []syscall.SysProcIDMap
+}
+
+// DefaultNamespaces 返回 run 命令默认创建的 namespace，cgroup namespace 由 init 进程根据 InitConfig.CgroupNS 创建
+func DefaultNamespaces() *Namespaces {
+	return &Namespaces{
+		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS |
+			syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC,
+	}
+}
+
+// RootIDs 返回容器内的 root 在宿主机上对应的 uid 和 gid，没有创建 user namespace 时就是宿主机上的 root
+func (n *Namespaces) RootIDs() (int, int, error) {
+	if n.Cloneflags&syscall.CLONE_NEWUSER == 0 {
+		return 0, 0, nil
+	}
+	uid, ok := hostID(n.UIDMappings, 0)
+	if !ok {
+		return -1, -1, fmt.Errorf("uid 0 is not mapped in uidMappings")
+	}
+	gid, ok := hostID(n.GIDMappings, 0)
+	if !ok {
+		return -1, -1, fmt.Errorf("gid 0 is not mapped in gidMappings")
+	}
+	return uid, gid, nil
+}
+
+func hostID(mappings []syscall.SysProcIDMap, containerID int) (int, bool) {
+	for _, m := range mappings {
+		if containerID >= m.ContainerID && containerID < m.ContainerID+m.Size {
+			return m.HostID + containerID - m.ContainerID, true
+		}
+	}
+	return -1, false
+}
+
 // NewParentProcess 构造出一个 command：
 // 1. 调用 /proc/self/exe，使用这种方式对创造出来的进程进行初始化，并隔离新的 namespace 中执行
 // 2. 其中 init 是传递给本进程的第一个参数，表示 fork 出的进程会执行我们的 init 命令
 // 3. 如果用户指定了 -it 参数，就需要把当前进程的输入输出导入到标准输入输出上
-func NewParentProcess(tty bool, workspace, containerName string, envs []string) (*exec.Cmd, *os.File, error) {
-	readPipe, writePipe, err := newPipe()
+// 容器的命令、环境变量等配置在进程启动之后通过返回的 InitPipe 发送，init 进程完成初始化后在 exec fifo 上等待 start。
+// cgroup namespace 不在 clone 时创建：此时子进程还没有加入容器的 cgroup，namespace 的根目录会是宿主机上 my-runc 所在的 cgroup，
+// 因此由 init 进程在父进程将其加入 cgroup 之后再调用 unshare 创建
+func NewParentProcess(tty bool, workspace, containerName string, namespaces *Namespaces) (
+	*exec.Cmd, *InitPipe, error) {
+	initPipe, err := newInitPipe(containerName)
 	if err != nil {
 		return nil, nil, err
 	}
 	cmd := exec.Command("/proc/self/exe", "init")
+	// 创建 user namespace 时允许 init 进程调用 setgroups，否则切换用户时无法清空附加组
 	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS |
-			syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC,
+		Cloneflags:                 namespaces.Cloneflags,
+		UidMappings:                namespaces.UIDMappings,
+		GidMappings:                namespaces.GIDMappings,
+		GidMappingsEnableSetgroups: len(namespaces.GIDMappings) > 0,
+	}
+	// 宿主机上的 root 在新的 user namespace 中没有映射，需要切换为容器内的 root，否则 exec 之后会失去所有 capability
+	if namespaces.Cloneflags&syscall.CLONE_NEWUSER != 0 {
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: 0, Gid: 0}
 	}
 	if tty {
 		cmd.Stdin = os.Stdin
@@ -39,40 +105,103 @@ func NewParentProcess(tty bool, workspace, containerName string, envs []string)
 		stdoutFile, err = CreateLogFile(containerName)
 		if err != nil {
 			logrus.Errorf("failed to create log file for %v: %v", containerName, err)
+			initPipe.Close()
 			return nil, nil, err
 		}
 		cmd.Stdout = stdoutFile
 	}
 	cmd.Dir = workspace
 	// 将管道的一端传入 fork 的进程中
-	cmd.ExtraFiles = []*os.File{readPipe}
-	// 在容器进程内设置环境变量
-	cmd.Env = append(cmd.Env, os.Environ()...)
-	cmd.Env = append(cmd.Env, envs...)
-	return cmd, writePipe, nil
+	cmd.ExtraFiles = initPipe.ExtraFiles()
+	return cmd, initPipe, nil
 }
 
 // RunContainerInitProcess 是在容器内部执行的，会执行一些初始化操作。
 // 代码执行到这里时，容器所在的进程其实就已经创建出来了，这是本容器执行的第一个进程。
-// 使用 mount 先去挂载 proc 文件系统，
-// 然后执行 execve 替换掉 /proc/self/exe，将用户传入的命令参数，作为 1 号进程
+// 初始化的进度和失败的原因通过 sync socket 报告给父进程
 func RunContainerInitProcess() error {
-	if err := setUpMount(); err != nil {
-		logrus.Errorf("failed to set up mount: %v", err)
-		return err
+	// sync socket 在执行用户命令时自动关闭，父进程读到 EOF 即表示用户命令执行成功
+	syscall.CloseOnExec(initSyncFd)
+	syscall.CloseOnExec(initExecFifoFd)
+	sync := newInitSync()
+	// 父进程在将容器进程加入 cgroup 之后才会发送配置，因此读到配置时已经处于容器的 cgroup 中
+	config, err := readInitConfig(os.NewFile(uintptr(initConfigFd), "config-pipe"))
+	if err == nil {
+		err = initContainer(config, sync)
+	}
+	if err != nil {
+		sync.reportError(err)
 	}
+	return err
+}
 
-	args := readArgs()
+// initContainer 根据配置初始化容器，使用 mount 先去挂载 proc 文件系统，
+// 然后执行 execve 替换掉 /proc/self/exe，将用户传入的命令参数，作为 1 号进程
+func initContainer(config *InitConfig, sync *initSync) error {
+	args := config.Args
 	logrus.Infof("init container for args: %+v", args)
 
+	if config.CgroupNS == CgroupNSPrivate {
+		// cgroup namespace 只对调用 unshare 的线程生效，需要保证之后的挂载和 execve 都在同一个线程中执行
+		runtime.LockOSThread()
+		if err := unix.Unshare(unix.CLONE_NEWCGROUP); err != nil {
+			logrus.Errorf("failed to unshare cgroup namespace: %v", err)
+			return fmt.Errorf("failed to unshare cgroup namespace: %v", err)
+		}
+	}
+	if len(config.Hostname) > 0 {
+		if err := unix.Sethostname([]byte(config.Hostname)); err != nil {
+			logrus.Errorf("failed to set hostname %v: %v", config.Hostname, err)
+			return fmt.Errorf("failed to set hostname %v: %v", config.Hostname, err)
+		}
+	}
+	if err := setUpMount(config.Mounts, config.Devices); err != nil {
+		logrus.Errorf("failed to set up mount: %v", err)
+		return err
+	}
+	if err := sync.send(syncMountsReady, ""); err != nil {
+		return err
+	}
+
 	processOne, err := util.ShowProcessesInSpecifyPath("./old-process-one")
 	if err != nil {
 		logrus.Errorf("failed to get process one: %v", err)
 		return err
 	}
 	logrus.Infof("old process one: \n%v", processOne)
+	if config.ReadonlyRootfs {
+		if err = syscall.Mount("", "/", "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); err != nil {
+			logrus.Errorf("failed to remount rootfs as readonly: %v", err)
+			return fmt.Errorf("failed to remount rootfs as readonly: %v", err)
+		}
+	}
 
-	// 同时，我们使用 lookPath 的方式去查找命令进行执行
+	cwd := config.Cwd
+	if len(cwd) == 0 {
+		cwd = "/"
+	}
+	if err = os.Chdir(cwd); err != nil {
+		logrus.Errorf("failed to change directory to %v: %v", cwd, err)
+		return fmt.Errorf("failed to change directory to %v: %v", cwd, err)
+	}
+	if err = setUpRlimits(config.Rlimits); err != nil {
+		logrus.Errorf("failed to set up ulimits: %v", err)
+		return err
+	}
+	// 切换用户之后无法再通过 /proc/self/fd 打开 exec fifo，因此先检查用户是否合法，start 之后再切换
+	if len(config.User) > 0 {
+		if _, _, err = parseUser(config.User); err != nil {
+			return err
+		}
+	}
+
+	// 使用容器的环境变量查找命令，同名的环境变量以后者为准
+	os.Clearenv()
+	for _, env := range config.Env {
+		if kv := strings.SplitN(env, "=", 2); len(kv) == 2 {
+			_ = os.Setenv(kv[0], kv[1])
+		}
+	}
 	var execPath string
 	execPath, err = exec.LookPath(args[0])
 	if err != nil {
@@ -80,17 +209,22 @@ func RunContainerInitProcess() error {
 		return err
 	}
 	logrus.Infof("find exec path: %s", execPath)
-	args[0] = execPath
-	if err = syscall.Exec(args[0], args, os.Environ()); err != nil {
-		logrus.Errorf("exec (%+v) failed: %v", args, err)
+	// 通知父进程初始化已经完成，然后阻塞在 exec fifo 上，直到 start 之后再执行用户命令
+	if err = sync.send(syncExecReady, ""); err != nil {
 		return err
 	}
-	processOne, err = util.ShowProcessesInSpecifyPath("./new-process-one")
-	if err != nil {
-		logrus.Errorf("failed to get process one: %v", err)
+	if err = waitExecFifo(); err != nil {
+		logrus.Errorf("failed to wait for start: %v", err)
+		return err
+	}
+	if err = setUpUser(config.User, config.AdditionalGids); err != nil {
+		logrus.Errorf("failed to set up user: %v", err)
 		return err
 	}
-	logrus.Infof("new process one: \n%v", processOne)
+	if err = syscall.Exec(execPath, args, os.Environ()); err != nil {
+		logrus.Errorf("exec (%+v) failed: %v", args, err)
+		return fmt.Errorf("exec %v failed: %v", execPath, err)
+	}
 	return nil
 }
 
@@ -109,9 +243,9 @@ func CommitContainer(containerName string) error {
 }
 
 func ListContainers() error {
-	files, err := ioutil.ReadDir(DefaultMetadataRootDir)
+	files, err := ioutil.ReadDir(metadataRootDir)
 	if err != nil {
-		logrus.Errorf("failed to read directory (%v): %v", DefaultMetadataRootDir, err)
+		logrus.Errorf("failed to read directory (%v): %v", metadataRootDir, err)
 		return err
 	}
 
@@ -126,6 +260,7 @@ func ListContainers() error {
 			logrus.Errorf("failed to read metadata of container (%v): %v", files[i].Name(), err)
 			return err
 		}
+		refreshMetadata(metadata)
 		containers[i] = metadata
 	}
 	sort.Slice(containers, func(i, j int) bool {
@@ -133,11 +268,11 @@ func ListContainers() error {
 	})
 
 	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
-	_, _ = fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tCOMMAND\tCREATED\tIP\n")
+	_, _ = fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tRESTARTS\tCOMMAND\tCREATED\tIP\tPIDS(CUR/PEAK)\n")
 	for _, ctn := range containers {
 		ipNets := ctn.GetIPNets()
-		_, _ = fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", ctn.ID, ctn.Name, ctn.PID, ctn.Status, ctn.Command,
-			ctn.CreateTime, ipNets)
+		_, _ = fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", ctn.ID, ctn.Name, ctn.PID, ctn.GetStatus(),
+			ctn.RestartCount, ctn.Command, ctn.CreateTime, ipNets, ctn.GetPids())
 	}
 	if err = w.Flush(); err != nil {
 		return fmt.Errorf("flush ps write err: %v", err)
@@ -145,6 +280,86 @@ func ListContainers() error {
 	return nil
 }
 
+// InspectContainer 以 JSON 格式输出容器的元数据以及 cgroup 中的实时状态
+func InspectContainer(containerName string) error {
+	metadata, err := ReadMetadata(containerName)
+	if err != nil {
+		logrus.Errorf("failed to read metadata of %v: %v", containerName, err)
+		return err
+	}
+	refreshMetadata(metadata)
+	info := &Info{Metadata: metadata}
+	if len(metadata.CgroupPath) > 0 {
+		if info.Stats, err = metadata.CgroupManager().GetStats(); err != nil {
+			logrus.Warningf("failed to get stats of container %v: %v", containerName, err)
+		}
+	}
+
+	var body []byte
+	body, err = json.MarshalIndent(info, "", "    ")
+	if err != nil {
+		logrus.Errorf("failed to marshal %v: %v", info, err)
+		return err
+	}
+	if _, err = fmt.Fprintln(os.Stdout, string(body)); err != nil {
+		logrus.Errorf("failed to print info of container %v: %v", containerName, err)
+		return err
+	}
+	return nil
+}
+
+// refreshMetadata 根据容器的实际状态修正元数据，发生变化时在锁的保护下基于最新的元数据修正并写回，
+// 避免覆盖监控进程在此期间记录的退出状态
+func refreshMetadata(metadata *Metadata) {
+	if !metadata.refreshStatus() {
+		return
+	}
+	latest, err := UpdateMetadata(metadata.Name, func(latest *Metadata) bool {
+		return latest.refreshStatus()
+	})
+	if err != nil {
+		logrus.Warningf("failed to refresh metadata of %v: %v", metadata.Name, err)
+		return
+	}
+	*metadata = *latest
+}
+
+// WatchOOM 在后台监听容器 cgroup 的 OOM 事件，发生 OOM 时记录到容器元数据中
+func WatchOOM(containerName string) error {
+	metadata, err := ReadMetadata(containerName)
+	if err != nil {
+		logrus.Errorf("failed to read metadata of %v: %v", containerName, err)
+		return err
+	}
+	var ch <-chan struct{}
+	if ch, err = metadata.CgroupManager().NotifyOOM(); err != nil {
+		logrus.Errorf("failed to watch oom events of container %v: %v", metadata.Name, err)
+		return err
+	}
+	go func() {
+		for range ch {
+			// 禁用了 OOM killer 时进程只会被挂起，不会被杀死
+			if metadata.Resources != nil && metadata.Resources.OOMKillDisable {
+				logrus.Warningf("container %v is out of memory, processes are paused by the kernel", metadata.Name)
+				continue
+			}
+			logrus.Warningf("container %v is out of memory", metadata.Name)
+			if recordErr := RecordOOMKilled(metadata.Name); recordErr != nil {
+				logrus.Warningf("failed to record oom of container %v: %v", metadata.Name, recordErr)
+			}
+		}
+	}()
+	return nil
+}
+
+// RecordOOMKilled 在容器元数据中记录 OOM kill
+func RecordOOMKilled(containerName string) error {
+	_, err := UpdateMetadata(containerName, func(metadata *Metadata) bool {
+		return metadata.markOOMKilled()
+	})
+	return err
+}
+
 // LogContainer 读取日志文件并输出到标准输出上
 func LogContainer(containerName string) error {
 	logPath := generateLogPath(containerName)
@@ -160,34 +375,198 @@ func LogContainer(containerName string) error {
 	return nil
 }
 
-// StopContainer 停止当前运行的容器
-func StopContainer(containerName string) error {
+// StopContainer 向容器的 init 进程发送 stop signal（默认为 SIGTERM），超过 timeout 仍未退出时发送 SIGKILL，
+// 确认进程退出之后才将容器状态记录为 stopped，被停止的容器不会再按照重启策略重启。
+// 容器的 init 进程是 PID namespace 中的 1 号进程，内核会忽略它没有注册处理函数的信号（SIGKILL 除外）。
+// 停止之后保留容器的 cgroup，以便查看峰值用量和 OOM 记录，由 rm 命令删除
+func StopContainer(containerName string, timeout time.Duration) error {
+	// 标记为手动停止之后，监控进程不会再按照重启策略重启容器，需要在锁的保护下与监控进程记录的状态合并
+	var restarting bool
+	metadata, err := UpdateMetadata(containerName, func(metadata *Metadata) bool {
+		changed := metadata.refreshStatus()
+		switch metadata.Status {
+		case StatusRestarting:
+			restarting = true
+			metadata.PID = 0
+			metadata.Status = StatusStopped
+		case StatusCreated, StatusRunning, StatusPaused:
+		default:
+			return changed
+		}
+		metadata.ManuallyStopped = true
+		return true
+	})
+	if err != nil {
+		logrus.Errorf("failed to save metadata of %v: %v", containerName, err)
+		return err
+	}
+	if restarting {
+		logrus.Infof("%v has been stopped while restarting", metadata.Name)
+		return nil
+	}
+	if metadata.Status != StatusCreated && metadata.Status != StatusRunning && metadata.Status != StatusPaused {
+		logrus.Infof("container %v is already %v", containerName, metadata.Status)
+		return nil
+	}
+	stopSignal := syscall.SIGTERM
+	if len(metadata.StopSignal) > 0 {
+		if stopSignal, err = util.ParseSignal(metadata.StopSignal); err != nil {
+			logrus.Errorf("invalid stop signal of container %v: %v", containerName, err)
+			return err
+		}
+	}
+
+	pid := metadata.PID
+	if err = syscall.Kill(pid, stopSignal); err != nil && err != syscall.ESRCH {
+		logrus.Errorf("failed to kill -%v %v: %v", unix.SignalName(stopSignal), pid, err)
+		return err
+	}
+	// 被冻结的进程不会处理信号，需要先恢复运行
+	if metadata.Status == StatusPaused {
+		if err = metadata.CgroupManager().Freeze(cgroup.Thawed); err != nil {
+			logrus.Errorf("failed to unpause container %v: %v", metadata.Name, err)
+			return err
+		}
+	}
+	var exited bool
+	if exited, err = waitProcess(pid, timeout); err != nil {
+		logrus.Errorf("failed to wait process %v of container %v: %v", pid, containerName, err)
+		return err
+	}
+	if !exited {
+		logrus.Warningf("container %v did not exit within %v after %v, kill it", containerName, timeout,
+			unix.SignalName(stopSignal))
+		if err = syscall.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+			logrus.Errorf("failed to kill -SIGKILL %v: %v", pid, err)
+			return err
+		}
+		if exited, err = waitProcess(pid, killTimeout); err != nil {
+			logrus.Errorf("failed to wait process %v of container %v: %v", pid, containerName, err)
+			return err
+		}
+		if !exited {
+			return fmt.Errorf("container %v is still running after %v", containerName, killTimeout)
+		}
+	}
+
+	// 进程退出时监控进程可能已经记录了退出状态，需要基于最新的元数据修改
+	if _, err = UpdateMetadata(containerName, func(latest *Metadata) bool {
+		latest.PID = 0
+		latest.Status = StatusStopped
+		return true
+	}); err != nil {
+		logrus.Errorf("failed to save metadata of %v: %v", containerName, err)
+		return err
+	}
+	logrus.Infof("%v has been stopped", metadata.Name)
+	return nil
+}
+
+// KillContainer 向运行中的容器的 init 进程发送信号，容器的状态由监控进程在进程退出后记录
+func KillContainer(containerName string, signal syscall.Signal) error {
 	metadata, err := ReadMetadata(containerName)
 	if err != nil {
 		logrus.Errorf("failed to read metadata of %v: %v", containerName, err)
 		return err
 	}
+	refreshMetadata(metadata)
+	// 被冻结的进程只有在恢复运行后才会处理信号
+	if metadata.Status == StatusPaused {
+		return fmt.Errorf("container %v is paused, unpause it first", containerName)
+	}
+	if metadata.Status != StatusCreated && metadata.Status != StatusRunning {
+		return fmt.Errorf("container %v is not running, status: %v", containerName, metadata.Status)
+	}
+	if err = syscall.Kill(metadata.PID, signal); err != nil {
+		logrus.Errorf("failed to kill -%v %v: %v", unix.SignalName(signal), metadata.PID, err)
+		return err
+	}
+	logrus.Infof("%v has been sent to container %v", unix.SignalName(signal), containerName)
+	return nil
+}
 
-	pid := metadata.PID
-	if err = syscall.Kill(pid, syscall.SIGTERM); err != nil && err.Error() != "no such process" {
-		logrus.Errorf("failed to kill -TERM %v: %v", pid, err)
+// PauseContainer 通过 freezer 挂起容器内的所有进程
+func PauseContainer(containerName string) error {
+	return setFreezerState(containerName, StatusRunning, cgroup.Frozen, StatusPaused)
+}
+
+// UnpauseContainer 恢复被挂起的容器
+func UnpauseContainer(containerName string) error {
+	return setFreezerState(containerName, StatusPaused, cgroup.Thawed, StatusRunning)
+}
+
+// setFreezerState 将处于 from 状态的容器的 freezer 设置为 state，并将容器状态改为 to。
+// 检查状态和设置 freezer 都在元数据的文件锁保护下完成，避免容器在检查之后退出时，监控进程记录的 exited 被覆盖
+func setFreezerState(containerName, from, state, to string) error {
+	var stateErr error
+	_, err := UpdateMetadata(containerName, func(metadata *Metadata) bool {
+		changed := metadata.refreshStatus()
+		if metadata.Status != from {
+			if from == StatusRunning {
+				stateErr = fmt.Errorf("container %v is %v, only running container can be paused",
+					containerName, metadata.Status)
+			} else {
+				stateErr = fmt.Errorf("container %v is %v, not paused", containerName, metadata.Status)
+			}
+			return changed
+		}
+		if len(metadata.CgroupPath) == 0 {
+			stateErr = fmt.Errorf("cgroup path of container %v is unknown", containerName)
+			return changed
+		}
+		if stateErr = metadata.CgroupManager().Freeze(state); stateErr != nil {
+			stateErr = fmt.Errorf("failed to set freezer state of container %v to %v: %v", containerName, state,
+				stateErr)
+			return changed
+		}
+		metadata.Status = to
+		return true
+	})
+	if err != nil {
+		logrus.Errorf("failed to update metadata of %v: %v", containerName, err)
 		return err
 	}
+	if stateErr != nil {
+		logrus.Errorf("%v", stateErr)
+		return stateErr
+	}
+	logrus.Infof("freezer state of %v has been set to %v", containerName, state)
+	return nil
+}
+
+// UpdateContainer 修改运行中容器的资源限制，并将生效后的限制写回元数据
+func UpdateContainer(metadata *Metadata, res *cgroup.ResourceConfig) error {
+	if metadata.Status != StatusRunning && metadata.Status != StatusPaused {
+		logrus.Errorf("container %v is %v, only running or paused container can be updated", metadata.Name, metadata.Status)
+		return fmt.Errorf("container %v is %v, only running or paused container can be updated",
+			metadata.Name, metadata.Status)
+	}
+	if len(metadata.CgroupPath) == 0 {
+		logrus.Errorf("cgroup path of container %v is unknown", metadata.Name)
+		return fmt.Errorf("cgroup path of container %v is unknown", metadata.Name)
+	}
 
-	metadata.PID = 0
-	metadata.Status = StatusStopped
-	if err = SaveMetadata(metadata); err != nil {
+	if err := metadata.CgroupManager().Set(res); err != nil {
+		logrus.Errorf("failed to set resource (%+v) to cgroup of container %v: %v", res, metadata.Name, err)
+		return err
+	}
+	if _, err := UpdateMetadata(metadata.Name, func(latest *Metadata) bool {
+		latest.Resources = res
+		return true
+	}); err != nil {
 		logrus.Errorf("failed to save metadata of %v: %v", metadata.Name, err)
 		return err
 	}
-	logrus.Infof("%v has been stopped", metadata.Name)
+	metadata.Resources = res
+	logrus.Infof("resources of %v have been updated to %+v", metadata.Name, res)
 	return nil
 }
 
 // RemoveContainer 删除当前容器的信息
 func RemoveContainer(metadata *Metadata) error {
 	containerName := metadata.Name
-	if metadata.Status != StatusStopped {
+	refreshMetadata(metadata)
+	if metadata.Status != StatusStopped && metadata.Status != StatusExited {
 		logrus.Warningf("please stop contaienr %v first", containerName)
 		return fmt.Errorf("please stop contaienr %v first", containerName)
 	}
@@ -199,6 +578,14 @@ func RemoveContainer(metadata *Metadata) error {
 	workspace := layer.GenerateWorkSpaceDir(rootDir, containerName)
 	workLayer := layer.GenerateWorkDir(rootDir, containerName)
 	writeLayer := layer.GenerateWriteDir(rootDir, containerName)
+	// 旧版本的元数据中没有记录 cgroup 路径，所有容器共用一个 cgroup，不能随意删除；
+	// cgroup 中仍有进程时删除会失败，此时保留 workspace 和元数据，便于再次执行 rm
+	if len(metadata.CgroupPath) > 0 {
+		if err = metadata.CgroupManager().Destroy(); err != nil {
+			logrus.Errorf("failed to destroy cgroup %v of container %v: %v", metadata.CgroupPath, containerName, err)
+			return err
+		}
+	}
 	layer.DeleteWorkspace(workspace, workLayer, writeLayer, metadata.Volumes)
 	metadataDir := generateMetadataDir(containerName)
 	if err = os.RemoveAll(metadataDir); err != nil {
@@ -217,17 +604,11 @@ func newPipe() (*os.File, *os.File, error) {
 	return readPipe, writePipe, nil
 }
 
-func readArgs() []string {
-	readPipe := os.NewFile(uintptr(3), "pipe")
-	msg, err := ioutil.ReadAll(readPipe)
-	if err != nil {
-		logrus.Errorf("failed to read args from pipe: %v", err)
-		return nil
-	}
-	return strings.Split(string(msg), " ")
-}
-
-func setUpMount() error {
+// setUpMount 在 pivot_root 之后完成容器内的挂载：bind mount 的源路径在宿主机上，通过 oldRootDir 访问，
+// 全部挂载完成之后卸载 oldRootDir
+func setUpMount(mounts []*Mount, devices []*cgroup.Device) error {
+	// pivot_root 之后容器内还没有 /sys/fs/cgroup，需要提前判断 cgroup 的版本
+	unified := util.IsCgroup2UnifiedMode()
 	if err := syscall.Mount("/", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
 		logrus.Errorf("failed to mount root in private way: %v", err)
 		return err
@@ -252,12 +633,171 @@ func setUpMount() error {
 	}
 	logrus.Infof("current directory is %v", pwd)
 
-	defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
-	if err = syscall.Mount("proc", "/proc", "proc", uintptr(defaultMountFlags), ""); err != nil {
-		logrus.Errorf("mount /proc failed: %v", err)
+	for _, m := range mounts {
+		if err = mount(m); err != nil {
+			logrus.Errorf("mount %v failed: %v", m.Destination, err)
+			return fmt.Errorf("mount %v failed: %v", m.Destination, err)
+		}
+	}
+	if err = setUpCgroupMount(unified); err != nil {
+		logrus.Errorf("mount %v failed: %v", util.CgroupRootDir, err)
+		return err
+	}
+	if err = setUpDevices(devices); err != nil {
+		return err
+	}
+	return unmountOldRoot()
+}
+
+// mount 执行一次挂载，bind mount 的源路径为文件时挂载点也创建为文件。
+// bind mount 会忽略 ro、nosuid 等标志位，需要再重新挂载一次
+func mount(m *Mount) error {
+	source := m.Source
+	bind := m.Flags&syscall.MS_BIND != 0
+	if bind {
+		source = path.Join(oldRootDir, m.Source)
+		if err := createMountPoint(source, m.Destination); err != nil {
+			return err
+		}
+	} else if err := os.MkdirAll(m.Destination, 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount(source, m.Destination, m.Type, m.Flags, m.Data); err != nil {
+		return err
+	}
+	if bind && m.Flags&^(syscall.MS_BIND|syscall.MS_REC) != 0 {
+		return syscall.Mount("", m.Destination, "", m.Flags|syscall.MS_REMOUNT, "")
+	}
+	return nil
+}
+
+// createMountPoint 根据 bind mount 的源路径创建同类型的挂载点
+func createMountPoint(source, destination string) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return os.MkdirAll(destination, 0755)
+	}
+	if err = os.MkdirAll(path.Dir(destination), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(destination, os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// setUpCgroupMount 以只读方式在容器的 /sys/fs/cgroup 挂载 cgroupfs，使容器内的程序可以读取自己的资源限制：
+// 1. cgroup v2 下直接挂载 cgroup2；
+// 2. cgroup v1 下先挂载 tmpfs，再根据 /proc/self/cgroup 为每个层级挂载对应的 subsystem，
+// 多个 subsystem 共用一个层级时（如 cpu,cpuacct），为每个 subsystem 创建指向该层级的符号链接。
+// 使用独立的 cgroup namespace 时，挂载点的根目录即为容器自己的 cgroup
+func setUpCgroupMount(unified bool) error {
+	if err := os.MkdirAll(util.CgroupRootDir, 0755); err != nil {
+		logrus.Errorf("failed to mkdir %v: %v", util.CgroupRootDir, err)
+		return err
+	}
+	flags := uintptr(syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV)
+	if unified {
+		return syscall.Mount("cgroup2", util.CgroupRootDir, "cgroup2", flags|syscall.MS_RDONLY, "")
+	}
+
+	if err := syscall.Mount("tmpfs", util.CgroupRootDir, "tmpfs", flags, "mode=755"); err != nil {
+		return err
+	}
+	body, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return err
+	}
+	// 每行的格式为 hierarchy-ID:controller-list:cgroup-path，如 4:memory:/，cgroup v2 的 controller-list 为空
+	for _, line := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 || len(fields[1]) == 0 {
+			continue
+		}
+		controllers := fields[1]
+		hierarchy := path.Join(util.CgroupRootDir, strings.TrimPrefix(controllers, "name="))
+		if err = os.MkdirAll(hierarchy, 0755); err != nil {
+			return err
+		}
+		if err = syscall.Mount("cgroup", hierarchy, "cgroup", flags|syscall.MS_RDONLY, controllers); err != nil {
+			return fmt.Errorf("failed to mount cgroup %v: %v", controllers, err)
+		}
+		if !strings.Contains(controllers, ",") {
+			continue
+		}
+		for _, controller := range strings.Split(controllers, ",") {
+			if err = os.Symlink(path.Base(hierarchy), path.Join(util.CgroupRootDir, controller)); err != nil {
+				return err
+			}
+		}
+	}
+	return syscall.Mount("", util.CgroupRootDir, "", flags|syscall.MS_REMOUNT|syscall.MS_RDONLY, "")
+}
+
+// setUpDevices 在容器的 /dev 中创建设备文件，挂载独立的 devpts，并创建 /dev/ptmx、/dev/fd 等常用的符号链接
+func setUpDevices(devices []*cgroup.Device) error {
+	oldMask := syscall.Umask(0)
+	defer syscall.Umask(oldMask)
+
+	for _, device := range devices {
+		if len(device.Path) == 0 {
+			continue
+		}
+		if err := os.MkdirAll(path.Dir(device.Path), 0755); err != nil {
+			logrus.Errorf("failed to mkdir parent of device %v: %v", device.Path, err)
+			return err
+		}
+		mode := uint32(device.FileMode.Perm())
+		if device.Type == cgroup.DeviceTypeBlock {
+			mode |= syscall.S_IFBLK
+		} else {
+			mode |= syscall.S_IFCHR
+		}
+		dev := int(unix.Mkdev(uint32(device.Major), uint32(device.Minor)))
+		// 用户指定的设备可能与默认设备的路径相同，以后者为准
+		if err := os.Remove(device.Path); err != nil && !os.IsNotExist(err) {
+			logrus.Errorf("failed to remove existing device %v: %v", device.Path, err)
+			return err
+		}
+		err := syscall.Mknod(device.Path, mode, dev)
+		if err == syscall.EPERM {
+			// user namespace 中没有创建设备文件的权限，改为 bind mount 宿主机上相同路径的设备文件
+			err = mount(&Mount{Source: device.Path, Destination: device.Path, Flags: syscall.MS_BIND})
+		}
+		if err != nil {
+			logrus.Errorf("failed to create device %v: %v", device.Path, err)
+			return fmt.Errorf("failed to create device %v: %v", device.Path, err)
+		}
+	}
+
+	if err := os.MkdirAll("/dev/pts", 0755); err != nil {
+		logrus.Errorf("failed to mkdir /dev/pts: %v", err)
 		return err
 	}
-	return syscall.Mount("tmpfs", "/dev", "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755")
+	// newinstance 使容器中的伪终端与宿主机隔离
+	if err := syscall.Mount("devpts", "/dev/pts", "devpts", syscall.MS_NOSUID|syscall.MS_NOEXEC,
+		"newinstance,ptmxmode=0666,mode=0620"); err != nil {
+		logrus.Errorf("mount /dev/pts failed: %v", err)
+		return err
+	}
+	links := [][2]string{
+		{"pts/ptmx", "/dev/ptmx"},
+		{"/proc/self/fd", "/dev/fd"},
+		{"/proc/self/fd/0", "/dev/stdin"},
+		{"/proc/self/fd/1", "/dev/stdout"},
+		{"/proc/self/fd/2", "/dev/stderr"},
+	}
+	for _, link := range links {
+		if err := os.Symlink(link[0], link[1]); err != nil {
+			logrus.Errorf("failed to create symlink %v -> %v: %v", link[1], link[0], err)
+			return err
+		}
+	}
+	return nil
 }
 
 func pivotRoot(root string) error {
@@ -270,7 +810,7 @@ func pivotRoot(root string) error {
 		return fmt.Errorf("failed to bind mount rootfs to itself: %v", err)
 	}
 
-	putOld := path.Join(root, ".pivot_root")
+	putOld := path.Join(root, oldRootDir)
 	if _, err := os.Stat(putOld); err == nil {
 		if err = os.RemoveAll(putOld); err != nil {
 			logrus.Errorf("failed to remove existing put_old directory failed: %v", err)
@@ -309,12 +849,15 @@ func pivotRoot(root string) error {
 	//}
 	//logrus.Infof("new mount: \n%v", mount)
 
-	// 取消临时文件 .pivot_root 的挂载并删除它
-	// 注意当前已经在根目录下，所以临时文件的目录也改变了
-	putOld = path.Join("/", ".pivot_root")
-	if err := syscall.Unmount(putOld, syscall.MNT_DETACH); err != nil {
+	return nil
+}
+
+// unmountOldRoot 取消临时文件 .pivot_root 的挂载并删除它
+// 注意当前已经在根目录下，所以临时文件的目录也改变了
+func unmountOldRoot() error {
+	if err := syscall.Unmount(oldRootDir, syscall.MNT_DETACH); err != nil {
 		logrus.Errorf("failed to umount put_old directory: %v", err)
 		return fmt.Errorf("failed to umount put_old directory: %v", err)
 	}
-	return os.Remove(putOld)
+	return os.Remove(oldRootDir)
 }
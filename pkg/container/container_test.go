@@ -0,0 +1,56 @@
+package container
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetFreezerState(t *testing.T) {
+	defer useTempMetadataRootDir(t)()
+	pid := os.Getpid()
+	tests := []struct {
+		name     string
+		metadata *Metadata
+		pause    bool
+		status   string
+	}{
+		{
+			name:     "pause exited container",
+			metadata: &Metadata{Name: "test-exited", Status: StatusExited},
+			pause:    true,
+			status:   StatusExited,
+		},
+		{
+			// 容器进程已经退出但监控进程还没有记录时，状态被修正为 exited 而不是 paused
+			name:     "pause container whose process has exited",
+			metadata: &Metadata{Name: "test-stale", Status: StatusRunning, PID: pid, PIDStartTime: 1},
+			pause:    true,
+			status:   StatusExited,
+		},
+		{
+			name:     "unpause exited container",
+			metadata: &Metadata{Name: "test-unpause-exited", Status: StatusExited},
+			status:   StatusExited,
+		},
+		{
+			name:     "unpause running container",
+			metadata: &Metadata{Name: "test-unpause-running", Status: StatusRunning, PID: pid},
+			status:   StatusRunning,
+		},
+	}
+	for _, test := range tests {
+		assert.Equal(t, nil, SaveMetadata(test.metadata), test.name)
+		var err error
+		if test.pause {
+			err = PauseContainer(test.metadata.Name)
+		} else {
+			err = UnpauseContainer(test.metadata.Name)
+		}
+		assert.NotEqual(t, nil, err, test.name)
+		metadata, err := ReadMetadata(test.metadata.Name)
+		assert.Equal(t, nil, err, test.name)
+		assert.Equal(t, test.status, metadata.Status, test.name)
+	}
+}
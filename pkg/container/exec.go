@@ -1,6 +1,7 @@
 package container
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
 	"strconv"
@@ -22,6 +23,11 @@ func ExecContainer(containerName string, args []string) error {
 		logrus.Errorf("failed to read metadata of container (%v): %v", containerName, err)
 		return err
 	}
+	// 被冻结的容器中新进程会立即被挂起，exec 会一直阻塞
+	if metadata.Status == StatusPaused {
+		logrus.Errorf("container %v is paused, unpause it first", containerName)
+		return fmt.Errorf("container %v is paused, unpause it first", containerName)
+	}
 
 	logrus.Infof("exec container with pid: %v", metadata.PID)
 	logrus.Infof("exec container with args: %+v", args)
@@ -40,6 +46,15 @@ func ExecContainer(containerName string, args []string) error {
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	// 子进程在 nsenter 中从 fd 3 读到数据之后才会进入容器并执行命令
+	readPipe, writePipe, err := newPipe()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = writePipe.Close()
+	}()
+	cmd.ExtraFiles = []*os.File{readPipe}
 
 	var envs []string
 	envs, err = GetEnvsOfContainer(containerName)
@@ -49,7 +64,29 @@ func ExecContainer(containerName string, args []string) error {
 	}
 	cmd.Env = append(cmd.Env, os.Environ()...)
 	cmd.Env = append(cmd.Env, envs...)
-	if err = cmd.Run(); err != nil {
+	err = cmd.Start()
+	_ = readPipe.Close()
+	if err != nil {
+		logrus.Errorf("failed to exec container %v with %+v: %v", containerName, args, err)
+		return err
+	}
+
+	// 将子进程而不是当前进程加入容器的 cgroup，子进程 fork 出的命令会继承，从而受到与容器相同的资源和设备限制
+	if len(metadata.CgroupPath) > 0 {
+		if err = metadata.CgroupManager().Apply(cmd.Process.Pid); err != nil {
+			logrus.Errorf("failed to join cgroup %v of container %v: %v", metadata.CgroupPath, containerName, err)
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+			return err
+		}
+	}
+	if _, err = writePipe.Write([]byte{0}); err != nil {
+		logrus.Errorf("failed to notify exec process of container %v: %v", containerName, err)
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return err
+	}
+	if err = cmd.Wait(); err != nil {
 		logrus.Errorf("failed to exec container %v with %+v: %v", containerName, args, err)
 		return err
 	}
@@ -0,0 +1,233 @@
+package container
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/wangao1236/my-runc/pkg/cgroup"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// InitConfigVersion 是父进程与容器 init 进程之间配置消息的版本，消息格式不兼容时需要增加
+	InitConfigVersion = 1
+
+	// initConfigFd、initSyncFd 和 initExecFifoFd 分别是容器 init 进程读取配置的管道、与父进程同步的 socket
+	// 和等待 start 的 exec fifo，对应 ExtraFiles 中的三个文件
+	initConfigFd   = 3
+	initSyncFd     = 4
+	initExecFifoFd = 5
+	// maxInitConfigSize 是配置消息的最大长度，避免读到错误的长度时分配过多的内存
+	maxInitConfigSize = 4 << 20
+)
+
+// rlimitTypes 是 --ulimit 支持的资源名称
+var rlimitTypes = map[string]int{
+	"as":         unix.RLIMIT_AS,
+	"core":       unix.RLIMIT_CORE,
+	"cpu":        unix.RLIMIT_CPU,
+	"data":       unix.RLIMIT_DATA,
+	"fsize":      unix.RLIMIT_FSIZE,
+	"locks":      unix.RLIMIT_LOCKS,
+	"memlock":    unix.RLIMIT_MEMLOCK,
+	"msgqueue":   unix.RLIMIT_MSGQUEUE,
+	"nice":       unix.RLIMIT_NICE,
+	"nofile":     unix.RLIMIT_NOFILE,
+	"nproc":      unix.RLIMIT_NPROC,
+	"rss":        unix.RLIMIT_RSS,
+	"rtprio":     unix.RLIMIT_RTPRIO,
+	"rttime":     unix.RLIMIT_RTTIME,
+	"sigpending": unix.RLIMIT_SIGPENDING,
+	"stack":      unix.RLIMIT_STACK,
+}
+
+// InitConfig 是父进程发送给容器 init 进程的配置，init 进程据此完成初始化后执行用户的命令
+type InitConfig struct {
+	Version int      `json:"version"`
+	Args    []string `json:"args"`
+	Env     []string `json:"env"`
+	// Cwd 是用户命令的工作目录，为空时为 /
+	Cwd string `json:"cwd,omitempty"`
+	// User 是执行用户命令的 uid[:gid]，为空时为 root，AdditionalGids 是用户的附加组
+	User           string `json:"user,omitempty"`
+	AdditionalGids []int  `json:"additionalGids,omitempty"`
+	// Hostname 是容器的主机名，为空时保留从宿主机继承的主机名
+	Hostname string    `json:"hostname,omitempty"`
+	Mounts   []*Mount  `json:"mounts"`
+	Rlimits  []*Rlimit `json:"rlimits,omitempty"`
+	// ReadonlyRootfs 为 true 时在初始化完成之后将根目录重新挂载为只读
+	ReadonlyRootfs bool `json:"readonlyRootfs,omitempty"`
+	// Devices 是需要在容器的 /dev 中创建的设备文件
+	Devices []*cgroup.Device `json:"devices"`
+	// CgroupNS 是 CgroupNSPrivate 或 CgroupNSHost
+	CgroupNS string `json:"cgroupNS"`
+}
+
+// Mount 表示 pivot_root 之后在容器内执行的一次挂载，bind mount 的 Source 是宿主机上的绝对路径
+type Mount struct {
+	Source      string  `json:"source"`
+	Destination string  `json:"destination"`
+	Type        string  `json:"type"`
+	Flags       uintptr `json:"flags"`
+	Data        string  `json:"data,omitempty"`
+}
+
+// Rlimit 表示容器进程的一项资源限制，Type 为 --ulimit 中的资源名称，如 nofile
+type Rlimit struct {
+	Type string `json:"type"`
+	Soft uint64 `json:"soft"`
+	Hard uint64 `json:"hard"`
+}
+
+// DefaultMounts 返回 pivot_root 之后挂载的 /proc 和 /dev
+func DefaultMounts() []*Mount {
+	return []*Mount{
+		{
+			Source:      "proc",
+			Destination: "/proc",
+			Type:        "proc",
+			Flags:       syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV,
+		},
+		{
+			Source:      "tmpfs",
+			Destination: "/dev",
+			Type:        "tmpfs",
+			Flags:       syscall.MS_NOSUID | syscall.MS_STRICTATIME,
+			Data:        "mode=755",
+		},
+	}
+}
+
+// ParseRlimit 解析 ${type}=${soft}[:${hard}] 形式的资源限制，如 nofile=1024:2048，hard 默认与 soft 相同
+func ParseRlimit(value string) (*Rlimit, error) {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid ulimit %v, expect ${type}=${soft}[:${hard}]", value)
+	}
+	limits := strings.SplitN(parts[1], ":", 2)
+	soft, err := parseRlimitValue(limits[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid soft limit in ulimit %v: %v", value, err)
+	}
+	hard := soft
+	if len(limits) == 2 {
+		if hard, err = parseRlimitValue(limits[1]); err != nil {
+			return nil, fmt.Errorf("invalid hard limit in ulimit %v: %v", value, err)
+		}
+	}
+	return NewRlimit(parts[0], soft, hard)
+}
+
+// NewRlimit 检查资源名称和限制的值，rlimitType 为 --ulimit 中的资源名称，如 nofile
+func NewRlimit(rlimitType string, soft, hard uint64) (*Rlimit, error) {
+	if _, ok := rlimitTypes[rlimitType]; !ok {
+		return nil, fmt.Errorf("unsupported ulimit type %v", rlimitType)
+	}
+	if soft > hard {
+		return nil, fmt.Errorf("soft limit %v is greater than hard limit %v in ulimit %v", soft, hard, rlimitType)
+	}
+	return &Rlimit{Type: rlimitType, Soft: soft, Hard: hard}, nil
+}
+
+// parseRlimitValue 解析资源限制的值，-1 和 unlimited 表示不限制
+func parseRlimitValue(value string) (uint64, error) {
+	if value == "-1" || value == "unlimited" {
+		return unix.RLIM_INFINITY, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// parseUser 解析 uid[:gid] 形式的用户，gid 默认为 0
+func parseUser(user string) (int, int, error) {
+	parts := strings.SplitN(user, ":", 2)
+	uid, err := strconv.Atoi(parts[0])
+	if err != nil || uid < 0 {
+		return 0, 0, fmt.Errorf("invalid user %v, only numeric uid[:gid] is supported", user)
+	}
+	gid := 0
+	if len(parts) == 2 {
+		if gid, err = strconv.Atoi(parts[1]); err != nil || gid < 0 {
+			return 0, 0, fmt.Errorf("invalid user %v, only numeric uid[:gid] is supported", user)
+		}
+	}
+	return uid, gid, nil
+}
+
+func writeInitConfig(w io.Writer, config *InitConfig) error {
+	body, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(body)))
+	if _, err = w.Write(append(header, body...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func readInitConfig(r io.Reader) (*InitConfig, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read length of init config: %v", err)
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size > maxInitConfigSize {
+		return nil, fmt.Errorf("init config is too large: %v bytes", size)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("failed to read init config: %v", err)
+	}
+	config := &InitConfig{}
+	if err := json.Unmarshal(body, config); err != nil {
+		return nil, fmt.Errorf("invalid init config: %v", err)
+	}
+	if config.Version != InitConfigVersion {
+		return nil, fmt.Errorf("unsupported init config version %v, expect %v", config.Version, InitConfigVersion)
+	}
+	if len(config.Args) == 0 {
+		return nil, fmt.Errorf("missing container command")
+	}
+	return config, nil
+}
+
+// setUpRlimits 设置容器进程的资源限制，会被用户命令继承
+func setUpRlimits(rlimits []*Rlimit) error {
+	for _, rlimit := range rlimits {
+		resource, ok := rlimitTypes[rlimit.Type]
+		if !ok {
+			return fmt.Errorf("unsupported ulimit type %v", rlimit.Type)
+		}
+		if err := unix.Setrlimit(resource, &unix.Rlimit{Cur: rlimit.Soft, Max: rlimit.Hard}); err != nil {
+			return fmt.Errorf("failed to set ulimit %v: %v", rlimit.Type, err)
+		}
+	}
+	return nil
+}
+
+// setUpUser 切换到执行用户命令的用户，需要在挂载等需要特权的操作之后执行
+func setUpUser(user string, additionalGids []int) error {
+	if len(user) == 0 {
+		return nil
+	}
+	uid, gid, err := parseUser(user)
+	if err != nil {
+		return err
+	}
+	if err = syscall.Setgroups(append([]int{}, additionalGids...)); err != nil {
+		return fmt.Errorf("failed to set groups: %v", err)
+	}
+	if err = syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("failed to set gid %v: %v", gid, err)
+	}
+	if err = syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("failed to set uid %v: %v", uid, err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package container
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestInitConfig(t *testing.T) {
+	config := &InitConfig{
+		Version: InitConfigVersion,
+		Args:    []string{"sh", "-c", "echo a  b", ""},
+		Env:     []string{"FOO=bar"},
+		Mounts:  DefaultMounts(),
+	}
+	buf := &bytes.Buffer{}
+	assert.Equal(t, nil, writeInitConfig(buf, config))
+	decoded, err := readInitConfig(buf)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, config, decoded)
+
+	config.Version = InitConfigVersion + 1
+	assert.Equal(t, nil, writeInitConfig(buf, config))
+	_, err = readInitConfig(buf)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestParseRlimit(t *testing.T) {
+	rlimit, err := ParseRlimit("nofile=1024:2048")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &Rlimit{Type: "nofile", Soft: 1024, Hard: 2048}, rlimit)
+
+	rlimit, err = ParseRlimit("core=unlimited")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &Rlimit{Type: "core", Soft: unix.RLIM_INFINITY, Hard: unix.RLIM_INFINITY}, rlimit)
+
+	_, err = ParseRlimit("nofile=2048:1024")
+	assert.NotEqual(t, nil, err)
+	_, err = ParseRlimit("unknown=1")
+	assert.NotEqual(t, nil, err)
+}
+
+func TestParseUser(t *testing.T) {
+	uid, gid, err := parseUser("1000:100")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1000, uid)
+	assert.Equal(t, 100, gid)
+
+	uid, gid, err = parseUser("1000")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1000, uid)
+	assert.Equal(t, 0, gid)
+
+	_, _, err = parseUser("nobody")
+	assert.NotEqual(t, nil, err)
+}
@@ -10,21 +10,37 @@ import (
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/wangao1236/my-runc/pkg/cgroup"
 	"github.com/wangao1236/my-runc/pkg/types"
 	"github.com/wangao1236/my-runc/pkg/util"
+	"golang.org/x/sys/unix"
 )
 
 const (
+	// StatusCreated 表示容器已经创建，init 进程正在等待 start
+	StatusCreated = "created"
 	StatusRunning = "running"
+	StatusPaused  = "paused"
 	StatusStopped = "stopped"
 	StatusExited  = "exited"
+	// StatusRestarting 表示容器已经退出，监控进程正在按照重启策略等待重启
+	StatusRestarting = "restarting"
 
 	DefaultMetadataRootDir = "/var/run/my-runc/containers"
 	defaultContainerDir    = "default"
 	configName             = "config.json"
+	configLockName         = "config.lock"
 	logName                = "container.log"
+
+	// DefaultCgroupParent 是所有容器 cgroup 的默认父节点，每个容器的 cgroup 为 ${cgroup-parent}/${container-id}
+	DefaultCgroupParent = "my-runc"
+	// DefaultSystemdSlice 是使用 systemd 管理 cgroup 时的默认 slice，每个容器的 scope 为 my-runc-${container-id}.scope
+	DefaultSystemdSlice = "my-runc.slice"
 )
 
+// metadataRootDir 是保存容器元数据的目录，测试中会替换为临时目录，避免修改主机上容器的状态
+var metadataRootDir = DefaultMetadataRootDir
+
 func init() {
 	if err := util.EnsureDirectory(DefaultMetadataRootDir); err != nil {
 		logrus.Warningf("faile to ensure metadata root diectory %v: %v", DefaultMetadataRootDir, err)
@@ -42,6 +58,37 @@ type Metadata struct {
 	Volumes      []string          `json:"volumes"`
 	Endpoints    []*types.Endpoint `json:"endpoints"`
 	PortMappings map[int]int       `json:"portMappings"`
+	CgroupPath   string            `json:"cgroupPath"`
+	// PIDStartTime 是 init 进程的启动时间，用于判断 PID 被回收之后是否已经属于其他进程
+	PIDStartTime uint64 `json:"pidStartTime,omitempty"`
+	// Bundle 是通过 --bundle 创建的容器的 OCI bundle 目录
+	Bundle string `json:"bundle,omitempty"`
+	// Annotations 是 OCI bundle 的 config.json 中的 annotations
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// CgroupDriver 是管理容器 cgroup 的方式，为空时表示 cgroupfs
+	CgroupDriver string `json:"cgroupDriver,omitempty"`
+	// Resources 是容器当前生效的资源限制
+	Resources *cgroup.ResourceConfig `json:"resources"`
+	// OOMKilled 表示容器内是否有进程因内存超限被 OOM killer 杀死，OOMKilledAt 是第一次发现的时间
+	OOMKilled   bool       `json:"oomKilled"`
+	OOMKilledAt *time.Time `json:"oomKilledAt,omitempty"`
+	// ExitCode、ExitSignal 和 FinishedAt 由监控进程在容器 init 进程退出时记录，被信号杀死时退出码为 128 + 信号值
+	ExitCode   int        `json:"exitCode"`
+	ExitSignal string     `json:"exitSignal,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	// StopSignal 是 stop 命令向容器发送的信号，为空时使用 SIGTERM
+	StopSignal string `json:"stopSignal,omitempty"`
+	// RestartPolicy 是后台容器退出后的重启策略，RestartCount 是监控进程已经重启容器的次数，
+	// ManuallyStopped 表示容器被 stop 命令停止，此时不会再重启
+	RestartPolicy   *RestartPolicy `json:"restartPolicy,omitempty"`
+	RestartCount    int            `json:"restartCount"`
+	ManuallyStopped bool           `json:"manuallyStopped,omitempty"`
+}
+
+// Info 是 inspect 命令输出的容器信息，包括元数据以及从 cgroup 中读取的实时状态
+type Info struct {
+	*Metadata
+	Stats *cgroup.Stats `json:"stats,omitempty"`
 }
 
 func (m *Metadata) String() string {
@@ -65,17 +112,133 @@ func (m *Metadata) GetIPNets() string {
 	return "null"
 }
 
-// CreateMetadata 在容器创建时，将元数据存入配置文件中
-func CreateMetadata(pid int, args []string, containerName string, volumes []string) error {
-	return SaveMetadata(&Metadata{
-		PID:        pid,
-		ID:         util.RandomString(10),
-		Name:       containerName,
-		Command:    strings.Join(args, " "),
-		CreateTime: time.Now(),
-		Status:     StatusRunning,
-		Volumes:    volumes,
-	})
+// GetStatus 返回容器状态，已退出和等待重启的容器附加上一次的退出码，
+// 容器内有进程被 OOM killer 杀死时附加说明，如：exited (137) (OOMKilled)
+func (m *Metadata) GetStatus() string {
+	status := m.Status
+	if m.FinishedAt != nil && (m.Status == StatusExited || m.Status == StatusStopped || m.Status == StatusRestarting) {
+		status = fmt.Sprintf("%v (%v)", status, m.ExitCode)
+	}
+	if m.OOMKilled {
+		return status + " (OOMKilled)"
+	}
+	return status
+}
+
+// GetPids 返回容器内当前和历史最大的进程数，如：3/5，无法读取 cgroup 时返回 "-"
+func (m *Metadata) GetPids() string {
+	if len(m.CgroupPath) == 0 {
+		return "-"
+	}
+	stats, err := m.CgroupManager().GetStats()
+	if err != nil {
+		return "-"
+	}
+	return fmt.Sprintf("%v/%v", stats.Pids.Current, stats.Pids.Peak)
+}
+
+// CgroupManager 返回管理该容器 cgroup 的 Manager
+func (m *Metadata) CgroupManager() *cgroup.Manager {
+	return NewCgroupManager(m.CgroupDriver, m.CgroupPath)
+}
+
+// NewCgroupManager 根据 cgroup driver 返回对应的 Manager
+func NewCgroupManager(cgroupDriver, cgroupPath string) *cgroup.Manager {
+	if cgroupDriver == cgroup.DriverSystemd {
+		return cgroup.NewSystemdManager(cgroupPath)
+	}
+	return cgroup.NewManager(cgroupPath)
+}
+
+// markOOMKilled 记录容器发生了 OOM kill，只保留第一次发现的时间，返回元数据是否发生了变化
+func (m *Metadata) markOOMKilled() bool {
+	if m.OOMKilled {
+		return false
+	}
+	now := time.Now()
+	m.OOMKilled = true
+	m.OOMKilledAt = &now
+	return true
+}
+
+// setPID 记录容器的 init 进程及其启动时间
+func (m *Metadata) setPID(pid int) {
+	m.PID = pid
+	startTime, err := util.GetProcessStartTime(pid)
+	if err != nil {
+		logrus.Warningf("failed to get start time of process %v: %v", pid, err)
+	}
+	m.PIDStartTime = startTime
+}
+
+// isProcessAlive 返回容器的 init 进程是否还在运行，PID 已经被其他进程复用时返回 false
+func (m *Metadata) isProcessAlive() bool {
+	if !util.IsProcessAlive(m.PID) {
+		return false
+	}
+	if m.PIDStartTime == 0 {
+		return true
+	}
+	startTime, err := util.GetProcessStartTime(m.PID)
+	return err != nil || startTime == m.PIDStartTime
+}
+
+// refreshStatus 根据容器进程和 cgroup 的实际状态修正元数据，返回元数据是否发生了变化：
+// 1. 已创建、运行中或已挂起的容器进程已经退出时，状态改为 exited；
+// 2. cgroup 中有进程被 OOM killer 杀死过时，记录 OOMKilled
+func (m *Metadata) refreshStatus() bool {
+	changed := false
+	if (m.Status == StatusCreated || m.Status == StatusRunning || m.Status == StatusPaused) &&
+		!m.isProcessAlive() {
+		m.Status = StatusExited
+		changed = true
+	}
+	if !m.OOMKilled && len(m.CgroupPath) > 0 {
+		stats, err := m.CgroupManager().GetStats()
+		if err == nil && stats.Memory.OOMKills > 0 {
+			changed = m.markOOMKilled() || changed
+		}
+	}
+	return changed
+}
+
+// GenerateContainerID 生成容器 ID
+func GenerateContainerID() string {
+	return util.RandomString(10)
+}
+
+// GenerateCgroupPath 生成容器的 cgroup 路径：
+// 1. cgroupfs 下为 ${cgroup-parent}/${container-id}，如：my-runc/1281457058；
+// 2. systemd 下 cgroup-parent 为 slice 名称，如：my-runc.slice/my-runc-1281457058.scope
+func GenerateCgroupPath(cgroupDriver, cgroupParent, containerID string) (string, error) {
+	switch cgroupDriver {
+	case "", cgroup.DriverCgroupfs:
+		if len(cgroupParent) == 0 {
+			cgroupParent = DefaultCgroupParent
+		}
+		return path.Join(cgroupParent, containerID), nil
+	case cgroup.DriverSystemd:
+		if len(cgroupParent) == 0 {
+			cgroupParent = DefaultSystemdSlice
+		}
+		slicePath, err := cgroup.ExpandSlice(cgroupParent)
+		if err != nil {
+			return "", err
+		}
+		return path.Join(slicePath, fmt.Sprintf("my-runc-%v.scope", containerID)), nil
+	default:
+		return "", fmt.Errorf("unsupported cgroup driver %v, only %v and %v are supported",
+			cgroupDriver, cgroup.DriverCgroupfs, cgroup.DriverSystemd)
+	}
+}
+
+// CreateMetadata 在容器创建时，将调用方填好的元数据存入配置文件中，并记录创建时间和 init 进程的启动时间。
+// 容器的状态为 created，直到 start 之后才是 running
+func CreateMetadata(metadata *Metadata) error {
+	metadata.CreateTime = time.Now()
+	metadata.Status = StatusCreated
+	metadata.setPID(metadata.PID)
+	return SaveMetadata(metadata)
 }
 
 // ReadMetadata 读取容器元数据
@@ -108,21 +271,63 @@ func SaveMetadata(metadata *Metadata) error {
 		logrus.Errorf("failed to ensure metadata directory %v: %v", metadataDir, err)
 	}
 
-	var file *os.File
+	// 先写入临时文件再重命名，避免 wait 等命令读到写了一半的配置文件
 	configPath := generateConfigPath(containerName)
-	file, err = os.Create(configPath)
-	if err != nil {
-		logrus.Errorf("failed to create file of metadata (%v): %v", configPath, err)
+	tmpPath := configPath + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, body, 0644); err != nil {
+		logrus.Errorf("failed to write (%v) to metadata file (%v): %v", string(body), tmpPath, err)
 		return err
 	}
-
-	if _, err = file.Write(body); err != nil {
-		logrus.Errorf("failed to write (%v) to metadata file (%v): %v", string(body), configPath, err)
+	if err = os.Rename(tmpPath, configPath); err != nil {
+		logrus.Errorf("failed to rename %v to %v: %v", tmpPath, configPath, err)
 		return err
 	}
 	return nil
 }
 
+// UpdateMetadata 在容器元数据的文件锁保护下重新读取元数据并调用 update 修改，update 返回 true 时写回，返回最新的元数据。
+// ps、stop 等命令和监控进程会同时修改元数据，修改已有的元数据都需要通过它完成，避免写回旧的元数据覆盖其他进程的修改。
+// update 中不能再调用 UpdateMetadata，否则会死锁
+func UpdateMetadata(containerName string, update func(metadata *Metadata) bool) (*Metadata, error) {
+	unlock, err := lockMetadata(containerName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	metadata, err := ReadMetadata(containerName)
+	if err != nil {
+		logrus.Errorf("failed to read metadata of %v: %v", containerName, err)
+		return nil, err
+	}
+	if !update(metadata) {
+		return metadata, nil
+	}
+	if err = SaveMetadata(metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// lockMetadata 对容器目录下的 config.lock 加排他锁，返回解锁函数
+func lockMetadata(containerName string) (func(), error) {
+	lockPath := path.Join(generateMetadataDir(containerName), configLockName)
+	file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		logrus.Errorf("failed to open %v: %v", lockPath, err)
+		return nil, err
+	}
+	if err = unix.Flock(int(file.Fd()), unix.LOCK_EX); err != nil {
+		logrus.Errorf("failed to lock %v: %v", lockPath, err)
+		_ = file.Close()
+		return nil, err
+	}
+	return func() {
+		_ = unix.Flock(int(file.Fd()), unix.LOCK_UN)
+		_ = file.Close()
+	}, nil
+}
+
 // RemoveMetadata 在容器退出时，把元数据删除
 func RemoveMetadata(containerName string) error {
 	metadataDir := generateMetadataDir(containerName)
@@ -141,7 +346,8 @@ func CreateLogFile(containerName string) (*os.File, error) {
 	}
 
 	logPath := generateLogPath(containerName)
-	file, err := os.Create(logPath)
+	// 容器重启时继续追加到原有的日志中
+	file, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
 		logrus.Errorf("failed to create file of metadata (%v): %v", logPath, err)
 	}
@@ -170,7 +376,7 @@ func generateMetadataDir(containerName string) string {
 	if len(containerName) == 0 {
 		containerName = defaultContainerDir
 	}
-	return path.Join(DefaultMetadataRootDir, containerName)
+	return path.Join(metadataRootDir, containerName)
 }
 
 func generateConfigPath(containerName string) string {
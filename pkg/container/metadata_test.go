@@ -0,0 +1,97 @@
+package container
+
+import (
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wangao1236/my-runc/pkg/cgroup"
+)
+
+func TestUpdateMetadata(t *testing.T) {
+	defer useTempMetadataRootDir(t)()
+	assert.Equal(t, nil, SaveMetadata(&Metadata{Name: "test-update", Status: StatusRunning}))
+
+	// 每次修改都基于最新的元数据，并发修改不会丢失
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				_, err := UpdateMetadata("test-update", func(metadata *Metadata) bool {
+					metadata.RestartCount++
+					return true
+				})
+				assert.Equal(t, nil, err)
+			}
+		}()
+	}
+	wg.Wait()
+	metadata, err := ReadMetadata("test-update")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 100, metadata.RestartCount)
+
+	metadata, err = UpdateMetadata("test-update", func(metadata *Metadata) bool {
+		metadata.Status = StatusExited
+		return false
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, StatusExited, metadata.Status)
+	metadata, err = ReadMetadata("test-update")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, StatusRunning, metadata.Status)
+
+	_, err = UpdateMetadata("test-missing", func(metadata *Metadata) bool { return true })
+	assert.NotEqual(t, nil, err)
+}
+
+func TestGenerateCgroupPath(t *testing.T) {
+	tests := []struct {
+		driver     string
+		parent     string
+		cgroupPath string
+		hasErr     bool
+	}{
+		{driver: "", parent: "", cgroupPath: "my-runc/1281457058"},
+		{driver: cgroup.DriverCgroupfs, parent: "", cgroupPath: "my-runc/1281457058"},
+		{driver: cgroup.DriverCgroupfs, parent: "a/b", cgroupPath: "a/b/1281457058"},
+		{driver: cgroup.DriverSystemd, parent: "", cgroupPath: "my.slice/my-runc.slice/my-runc-1281457058.scope"},
+		{driver: cgroup.DriverSystemd, parent: "system.slice", cgroupPath: "system.slice/my-runc-1281457058.scope"},
+		{driver: cgroup.DriverSystemd, parent: "-.slice", cgroupPath: "my-runc-1281457058.scope"},
+		{driver: cgroup.DriverSystemd, parent: "my-runc", hasErr: true},
+		{driver: "unknown", hasErr: true},
+	}
+	for _, test := range tests {
+		name := test.driver + ":" + test.parent
+		cgroupPath, err := GenerateCgroupPath(test.driver, test.parent, "1281457058")
+		if test.hasErr {
+			assert.NotEqual(t, nil, err, name)
+			continue
+		}
+		assert.Equal(t, nil, err, name)
+		assert.Equal(t, test.cgroupPath, cgroupPath, name)
+	}
+}
+
+func TestCreateMetadata(t *testing.T) {
+	defer useTempMetadataRootDir(t)()
+	assert.Equal(t, nil, CreateMetadata(&Metadata{
+		ID:           "1281457058",
+		Name:         "test-create",
+		PID:          os.Getpid(),
+		CgroupDriver: cgroup.DriverCgroupfs,
+		CgroupPath:   "my-runc/1281457058",
+		StopSignal:   "SIGINT",
+	}))
+	metadata, err := ReadMetadata("test-create")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, StatusCreated, metadata.Status)
+	assert.Equal(t, os.Getpid(), metadata.PID)
+	assert.NotEqual(t, uint64(0), metadata.PIDStartTime)
+	assert.Equal(t, false, metadata.CreateTime.IsZero())
+	assert.Equal(t, "my-runc/1281457058", metadata.CgroupPath)
+	assert.Equal(t, cgroup.DriverCgroupfs, metadata.CgroupDriver)
+	assert.Equal(t, "SIGINT", metadata.StopSignal)
+}
@@ -0,0 +1,247 @@
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// EnvMyRuncMonitor 标记当前进程是后台容器的监控进程
+	EnvMyRuncMonitor = "my_runc_monitor"
+
+	monitorLogName = "monitor.log"
+	// monitorPipeFd 是监控进程通知命令行容器已经启动的管道，对应 ExtraFiles 中的第一个文件
+	monitorPipeFd = 3
+)
+
+var monitor bool
+
+// 检测到监控进程的环境变量后立即清除，避免被容器进程继承；通知管道设置为 close-on-exec，避免泄漏给 iptables 等子进程
+func init() {
+	if os.Getenv(EnvMyRuncMonitor) != "1" {
+		return
+	}
+	monitor = true
+	if err := os.Unsetenv(EnvMyRuncMonitor); err != nil {
+		logrus.Warningf("failed to unset env of (%v): %v", EnvMyRuncMonitor, err)
+	}
+	syscall.CloseOnExec(monitorPipeFd)
+}
+
+// IsMonitor 判断当前进程是否为后台容器的监控进程
+func IsMonitor() bool {
+	return monitor
+}
+
+// StartMonitor 在新的会话中启动监控进程，由监控进程重新执行 args 创建容器，并作为容器 init 进程的父进程等待其退出。
+// 命令行进程在容器创建（run -d 时为启动）后即退出，启动失败时监控进程的日志会直接输出在终端上
+func StartMonitor(args []string) error {
+	readPipe, writePipe, err := newPipe()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("/proc/self/exe", args...)
+	cmd.Env = append(os.Environ(), EnvMyRuncMonitor+"=1")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{writePipe}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err = cmd.Start(); err != nil {
+		logrus.Errorf("failed to start monitor process: %v", err)
+		return err
+	}
+	if err = writePipe.Close(); err != nil {
+		logrus.Warningf("failed to close write pipe: %v", err)
+	}
+
+	// 监控进程在容器启动后写入容器名称并关闭管道，没有读到内容说明监控进程在容器启动之前就退出了
+	msg, err := ioutil.ReadAll(readPipe)
+	if err != nil {
+		logrus.Errorf("failed to read from monitor process: %v", err)
+		return err
+	}
+	if len(msg) == 0 {
+		if err = cmd.Wait(); err != nil {
+			return fmt.Errorf("failed to start container: %v", err)
+		}
+		return fmt.Errorf("failed to start container: monitor process exited unexpectedly")
+	}
+	logrus.Infof("container %v has been created, pid of monitor process is %v", string(msg), cmd.Process.Pid)
+	return cmd.Process.Release()
+}
+
+// Monitor 在监控进程中执行：通知命令行容器已经启动，将自身的输出重定向到容器目录下的 monitor.log，
+// 然后等待容器 init 进程退出并记录其退出状态，需要重启时调用 restart 重新创建容器的 init 进程。
+// 容器还没有 start 时 initPipe 不为 nil，监控进程在 start 之后记录 init 进程执行用户命令失败的原因
+func Monitor(containerName string, parent *exec.Cmd, initPipe *InitPipe,
+	restart func(metadata *Metadata) (*exec.Cmd, error)) {
+	if err := detachMonitor(containerName); err != nil {
+		logrus.Errorf("failed to detach monitor process of container %v: %v", containerName, err)
+	}
+	if initPipe != nil {
+		if err := initPipe.WaitExec(); err != nil {
+			logrus.Errorf("failed to exec user command of container %v: %v", containerName, err)
+		}
+	}
+	var backoff time.Duration
+	for {
+		startedAt := time.Now()
+		if err := parent.Wait(); err != nil {
+			logrus.Infof("init process of container %v exited: %v", containerName, err)
+		}
+		if parent.ProcessState == nil {
+			return
+		}
+		if err := RecordExit(containerName, parent.ProcessState); err != nil {
+			logrus.Errorf("failed to record exit status of container %v: %v", containerName, err)
+			return
+		}
+		backoff = nextRestartBackoff(backoff, time.Since(startedAt))
+		if parent = restartContainer(containerName, backoff, restart); parent == nil {
+			return
+		}
+	}
+}
+
+// restartContainer 等待 backoff 之后重新创建容器的 init 进程，容器不需要重启、在等待期间被停止或者重启失败时返回 nil
+func restartContainer(containerName string, backoff time.Duration,
+	restart func(metadata *Metadata) (*exec.Cmd, error)) *exec.Cmd {
+	metadata, err := ReadMetadata(containerName)
+	if err != nil || metadata.Status != StatusRestarting {
+		return nil
+	}
+	logrus.Infof("restart container %v in %v, restart policy: %v", containerName, backoff, metadata.RestartPolicy)
+	time.Sleep(backoff)
+
+	// 等待期间容器可能被 stop 命令停止
+	if metadata, err = ReadMetadata(containerName); err != nil {
+		logrus.Errorf("failed to read metadata of %v: %v", containerName, err)
+		return nil
+	}
+	if metadata.Status != StatusRestarting || metadata.ManuallyStopped {
+		logrus.Infof("container %v is %v, give up restarting", containerName, metadata.Status)
+		return nil
+	}
+	parent, err := restart(metadata)
+	if err != nil {
+		logrus.Errorf("failed to restart container %v: %v", containerName, err)
+		if _, err = UpdateMetadata(containerName, func(latest *Metadata) bool {
+			if latest.Status != StatusRestarting {
+				return false
+			}
+			latest.Status = StatusExited
+			return true
+		}); err != nil {
+			logrus.Errorf("failed to save metadata of %v: %v", containerName, err)
+		}
+		return nil
+	}
+
+	// restart 期间执行的 stop 命令只会修改配置文件，需要在锁的保护下检查并记录新的 init 进程，
+	// 否则 stop 在检查之后写入的 ManuallyStopped 会被覆盖
+	var stopped bool
+	latest, err := UpdateMetadata(containerName, func(latest *Metadata) bool {
+		if latest.ManuallyStopped {
+			stopped = true
+			return false
+		}
+		latest.setPID(parent.Process.Pid)
+		latest.Endpoints = metadata.Endpoints
+		latest.Status = StatusRunning
+		latest.RestartCount++
+		return true
+	})
+	if err != nil {
+		logrus.Errorf("failed to save metadata of %v: %v", containerName, err)
+		return parent
+	}
+	if stopped {
+		logrus.Infof("container %v has been stopped while restarting, kill it", containerName)
+		if err = parent.Process.Kill(); err != nil {
+			logrus.Warningf("failed to kill init process of container %v: %v", containerName, err)
+		}
+		return parent
+	}
+	logrus.Infof("container %v has been restarted %v times, pid: %v", containerName, latest.RestartCount, latest.PID)
+	return parent
+}
+
+// detachMonitor 通过管道通知命令行容器已经启动，并将标准输入输出从终端重定向到日志文件
+func detachMonitor(containerName string) error {
+	pipe := os.NewFile(uintptr(monitorPipeFd), "monitor-pipe")
+	if _, err := pipe.WriteString(containerName); err != nil {
+		logrus.Errorf("failed to notify cli of container %v: %v", containerName, err)
+	}
+	if err := pipe.Close(); err != nil {
+		logrus.Warningf("failed to close monitor pipe: %v", err)
+	}
+
+	logPath := generateMonitorLogPath(containerName)
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		logrus.Errorf("failed to open %v: %v", logPath, err)
+		return err
+	}
+	defer func() {
+		_ = logFile.Close()
+	}()
+	var devNull *os.File
+	if devNull, err = os.Open(os.DevNull); err != nil {
+		return err
+	}
+	defer func() {
+		_ = devNull.Close()
+	}()
+	if err = unix.Dup2(int(devNull.Fd()), int(os.Stdin.Fd())); err != nil {
+		return err
+	}
+	for _, f := range []*os.File{os.Stdout, os.Stderr} {
+		if err = unix.Dup2(int(logFile.Fd()), int(f.Fd())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordExit 在容器元数据中记录 init 进程的退出状态，被信号杀死时退出码为 128 + 信号值，
+// 需要按照重启策略重启时状态记录为 restarting
+func RecordExit(containerName string, state *os.ProcessState) error {
+	exitCode, exitSignal, err := exitStatus(state)
+	if err != nil {
+		return err
+	}
+	_, err = UpdateMetadata(containerName, func(metadata *Metadata) bool {
+		now := time.Now()
+		metadata.FinishedAt = &now
+		metadata.ExitCode = exitCode
+		metadata.ExitSignal = exitSignal
+		// 通过 stop 命令停止的容器保留 stopped 状态，也不会再重启
+		if metadata.Status != StatusStopped {
+			metadata.Status = StatusExited
+			if !metadata.ManuallyStopped && metadata.RestartPolicy.ShouldRestart(metadata.ExitCode, metadata.RestartCount) {
+				metadata.Status = StatusRestarting
+			}
+		}
+		// OOM killer 杀死 init 进程时，OOM 事件可能还没有被记录
+		metadata.refreshStatus()
+		return true
+	})
+	if err != nil {
+		logrus.Errorf("failed to save metadata of %v: %v", containerName, err)
+		return err
+	}
+	logrus.Infof("container %v exited with code %v", containerName, exitCode)
+	return nil
+}
+
+func generateMonitorLogPath(containerName string) string {
+	return path.Join(generateMetadataDir(containerName), monitorLogName)
+}
@@ -0,0 +1,93 @@
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// RestartPolicyNo 表示容器退出后不重启
+	RestartPolicyNo = "no"
+	// RestartPolicyOnFailure 表示容器以非 0 退出码退出时重启，可以通过 on-failure:${max} 限制最大重启次数
+	RestartPolicyOnFailure = "on-failure"
+	// RestartPolicyAlways 表示容器退出后总是重启
+	RestartPolicyAlways = "always"
+	// RestartPolicyUnlessStopped 表示除非被 stop 命令停止，容器退出后总是重启。
+	// 容器由各自的监控进程重启，没有常驻的守护进程，因此与 always 的行为相同
+	RestartPolicyUnlessStopped = "unless-stopped"
+
+	// minRestartBackoff 和 maxRestartBackoff 是两次重启之间等待时间的下限和上限，每次重启等待时间翻倍
+	minRestartBackoff = 100 * time.Millisecond
+	maxRestartBackoff = time.Minute
+	// restartResetDuration 是重置等待时间所需的最短运行时间，容器运行超过该时间后再退出时从头开始计算等待时间
+	restartResetDuration = 10 * time.Second
+)
+
+// RestartPolicy 表示后台容器退出后的重启策略
+type RestartPolicy struct {
+	Name string `json:"name"`
+	// MaximumRetryCount 是 on-failure 策略下的最大重启次数，0 表示不限制
+	MaximumRetryCount int `json:"maximumRetryCount,omitempty"`
+}
+
+// ParseRestartPolicy 解析 no、on-failure[:max]、always、unless-stopped 形式的重启策略
+func ParseRestartPolicy(policy string) (*RestartPolicy, error) {
+	parts := strings.SplitN(policy, ":", 2)
+	name := parts[0]
+	switch name {
+	case RestartPolicyNo, RestartPolicyAlways, RestartPolicyUnlessStopped:
+		if len(parts) > 1 {
+			return nil, fmt.Errorf("maximum retry count can only be used with %v", RestartPolicyOnFailure)
+		}
+		return &RestartPolicy{Name: name}, nil
+	case RestartPolicyOnFailure:
+		restartPolicy := &RestartPolicy{Name: name}
+		if len(parts) > 1 {
+			count, err := strconv.Atoi(parts[1])
+			if err != nil || count < 0 {
+				return nil, fmt.Errorf("invalid maximum retry count %v", parts[1])
+			}
+			restartPolicy.MaximumRetryCount = count
+		}
+		return restartPolicy, nil
+	default:
+		return nil, fmt.Errorf("invalid restart policy %v, only %v, %v[:max], %v and %v are supported", policy,
+			RestartPolicyNo, RestartPolicyOnFailure, RestartPolicyAlways, RestartPolicyUnlessStopped)
+	}
+}
+
+func (p *RestartPolicy) String() string {
+	if p.Name == RestartPolicyOnFailure && p.MaximumRetryCount > 0 {
+		return fmt.Sprintf("%v:%v", p.Name, p.MaximumRetryCount)
+	}
+	return p.Name
+}
+
+// ShouldRestart 根据退出码和已经重启的次数判断容器是否需要重启
+func (p *RestartPolicy) ShouldRestart(exitCode, restartCount int) bool {
+	if p == nil {
+		return false
+	}
+	switch p.Name {
+	case RestartPolicyAlways, RestartPolicyUnlessStopped:
+		return true
+	case RestartPolicyOnFailure:
+		return exitCode != 0 && (p.MaximumRetryCount == 0 || restartCount < p.MaximumRetryCount)
+	default:
+		return false
+	}
+}
+
+// nextRestartBackoff 返回下一次重启前需要等待的时间，上一次运行的时间超过 restartResetDuration 时重新从下限开始
+func nextRestartBackoff(backoff, uptime time.Duration) time.Duration {
+	if backoff == 0 || uptime >= restartResetDuration {
+		return minRestartBackoff
+	}
+	backoff *= 2
+	if backoff > maxRestartBackoff {
+		return maxRestartBackoff
+	}
+	return backoff
+}
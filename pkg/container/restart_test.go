@@ -0,0 +1,44 @@
+package container
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRestartPolicy(t *testing.T) {
+	policy, err := ParseRestartPolicy("on-failure:3")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &RestartPolicy{Name: RestartPolicyOnFailure, MaximumRetryCount: 3}, policy)
+	assert.Equal(t, "on-failure:3", policy.String())
+
+	policy, err = ParseRestartPolicy("always")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, &RestartPolicy{Name: RestartPolicyAlways}, policy)
+
+	_, err = ParseRestartPolicy("always:3")
+	assert.NotEqual(t, nil, err)
+	_, err = ParseRestartPolicy("on-failure:-1")
+	assert.NotEqual(t, nil, err)
+	_, err = ParseRestartPolicy("sometimes")
+	assert.NotEqual(t, nil, err)
+}
+
+func TestShouldRestart(t *testing.T) {
+	onFailure := &RestartPolicy{Name: RestartPolicyOnFailure, MaximumRetryCount: 2}
+	assert.Equal(t, false, onFailure.ShouldRestart(0, 0))
+	assert.Equal(t, true, onFailure.ShouldRestart(1, 1))
+	assert.Equal(t, false, onFailure.ShouldRestart(1, 2))
+	assert.Equal(t, true, (&RestartPolicy{Name: RestartPolicyAlways}).ShouldRestart(0, 100))
+	assert.Equal(t, false, (&RestartPolicy{Name: RestartPolicyNo}).ShouldRestart(1, 0))
+	var none *RestartPolicy
+	assert.Equal(t, false, none.ShouldRestart(1, 0))
+}
+
+func TestNextRestartBackoff(t *testing.T) {
+	assert.Equal(t, minRestartBackoff, nextRestartBackoff(0, 0))
+	assert.Equal(t, 2*minRestartBackoff, nextRestartBackoff(minRestartBackoff, time.Second))
+	assert.Equal(t, maxRestartBackoff, nextRestartBackoff(maxRestartBackoff, time.Second))
+	assert.Equal(t, minRestartBackoff, nextRestartBackoff(maxRestartBackoff, restartResetDuration))
+}
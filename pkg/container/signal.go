@@ -0,0 +1,66 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// unforwardedSignals 是不需要转发给容器的信号：SIGCHLD 是容器 init 进程自身退出时发给当前进程的，
+// SIGURG 被 Go 运行时用于抢占调度
+var unforwardedSignals = map[syscall.Signal]bool{
+	syscall.SIGCHLD: true,
+	syscall.SIGURG:  true,
+}
+
+// ForwardSignals 将当前进程收到的所有可以捕获的信号转发给容器的 init 进程，返回的函数用于停止转发。
+// 容器的 init 进程是 PID namespace 中的 1 号进程，没有注册处理函数的信号（如 SIGINT、SIGTERM）会被内核忽略
+func ForwardSignals(pid int) func() {
+	ch := make(chan os.Signal, 128)
+	signal.Notify(ch)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for sig := range ch {
+			s, ok := sig.(syscall.Signal)
+			if !ok || unforwardedSignals[s] {
+				continue
+			}
+			logrus.Debugf("forward signal %v to init process %v", unix.SignalName(s), pid)
+			if err := syscall.Kill(pid, s); err != nil && err != syscall.ESRCH {
+				logrus.Warningf("failed to forward signal %v to init process %v: %v", unix.SignalName(s), pid, err)
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(ch)
+		<-done
+	}
+}
+
+// ExitCode 返回容器 init 进程的退出码，被信号杀死时为 128 + 信号值
+func ExitCode(state *os.ProcessState) int {
+	code, _, err := exitStatus(state)
+	if err != nil {
+		logrus.Warningf("failed to get exit code: %v", err)
+		return -1
+	}
+	return code
+}
+
+// exitStatus 返回进程的退出码以及杀死进程的信号名称，进程正常退出时信号名称为空
+func exitStatus(state *os.ProcessState) (int, string, error) {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok {
+		return -1, "", fmt.Errorf("unexpected process state %v", state)
+	}
+	if status.Signaled() {
+		return 128 + int(status.Signal()), unix.SignalName(status.Signal()), nil
+	}
+	return status.ExitStatus(), "", nil
+}
@@ -0,0 +1,37 @@
+package container
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		signal syscall.Signal
+		code   int
+		sig    string
+	}{
+		{name: "success", args: []string{"true"}, code: 0},
+		{name: "exit with code", args: []string{"sh", "-c", "exit 3"}, code: 3},
+		{name: "killed by SIGKILL", args: []string{"sleep", "10"}, signal: syscall.SIGKILL, code: 137, sig: "SIGKILL"},
+		{name: "killed by SIGTERM", args: []string{"sleep", "10"}, signal: syscall.SIGTERM, code: 143, sig: "SIGTERM"},
+	}
+	for _, test := range tests {
+		cmd := exec.Command(test.args[0], test.args[1:]...)
+		assert.Equal(t, nil, cmd.Start(), test.name)
+		if test.signal != 0 {
+			assert.Equal(t, nil, cmd.Process.Signal(test.signal), test.name)
+		}
+		_ = cmd.Wait()
+		assert.Equal(t, test.code, ExitCode(cmd.ProcessState), test.name)
+		code, sig, err := exitStatus(cmd.ProcessState)
+		assert.Equal(t, nil, err, test.name)
+		assert.Equal(t, test.code, code, test.name)
+		assert.Equal(t, test.sig, sig, test.name)
+	}
+}
@@ -0,0 +1,140 @@
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wangao1236/my-runc/pkg/util"
+	"golang.org/x/sys/unix"
+)
+
+const execFifoName = "exec.fifo"
+
+// createExecFifo 在容器目录下创建 exec fifo，返回以 O_PATH 打开的文件，用于传给 init 进程。
+// init 进程在 pivot_root 之后看不到宿主机上的路径，只能通过 /proc/self/fd 重新打开
+func createExecFifo(containerName string) (*os.File, error) {
+	metadataDir := generateMetadataDir(containerName)
+	if err := util.EnsureDirectory(metadataDir); err != nil {
+		logrus.Errorf("failed to ensure metadata directory %v: %v", metadataDir, err)
+		return nil, err
+	}
+	fifoPath := generateExecFifoPath(containerName)
+	if err := os.Remove(fifoPath); err != nil && !os.IsNotExist(err) {
+		logrus.Errorf("failed to remove stale exec fifo %v: %v", fifoPath, err)
+		return nil, err
+	}
+	if err := unix.Mkfifo(fifoPath, 0600); err != nil {
+		logrus.Errorf("failed to create exec fifo %v: %v", fifoPath, err)
+		return nil, err
+	}
+	// 使用 user namespace 时容器内的 root 在宿主机上不是 root，需要允许其他用户写入，mkfifo 的权限会受 umask 影响
+	if err := os.Chmod(fifoPath, 0622); err != nil {
+		logrus.Errorf("failed to chmod exec fifo %v: %v", fifoPath, err)
+		return nil, err
+	}
+	file, err := os.OpenFile(fifoPath, unix.O_PATH, 0)
+	if err != nil {
+		logrus.Errorf("failed to open exec fifo %v: %v", fifoPath, err)
+		return nil, err
+	}
+	return file, nil
+}
+
+// StartContainer 启动处于 created 状态的容器：打开 exec fifo 使 init 进程继续执行用户命令，并将状态记录为 running
+func StartContainer(containerName string) error {
+	metadata, err := ReadMetadata(containerName)
+	if err != nil {
+		logrus.Errorf("failed to read metadata of %v: %v", containerName, err)
+		return err
+	}
+	refreshMetadata(metadata)
+	if metadata.Status != StatusCreated {
+		return fmt.Errorf("container %v is %v, only created container can be started", containerName, metadata.Status)
+	}
+	if err = startInit(containerName, metadata.PID); err != nil {
+		logrus.Errorf("failed to start container %v: %v", containerName, err)
+		return err
+	}
+
+	// init 进程执行用户命令之后可能立即退出，监控进程记录的退出状态不能被覆盖
+	if _, err = UpdateMetadata(containerName, func(latest *Metadata) bool {
+		if latest.Status != StatusCreated {
+			return false
+		}
+		latest.Status = StatusRunning
+		return true
+	}); err != nil {
+		logrus.Errorf("failed to save metadata of %v: %v", containerName, err)
+		return err
+	}
+	logrus.Infof("container %v has been started", containerName)
+	return nil
+}
+
+// startInit 以只读方式打开 exec fifo，读到 init 进程写入的内容之后删除 fifo。
+// 打开 fifo 会阻塞到 init 进程以只写方式打开为止，因此需要同时检查 init 进程是否已经退出
+func startInit(containerName string, pid int) error {
+	fifoPath := generateExecFifoPath(containerName)
+	type result struct {
+		file *os.File
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		file, err := os.OpenFile(fifoPath, os.O_RDONLY, 0)
+		ch <- result{file: file, err: err}
+	}()
+	ticker := time.NewTicker(waitInterval)
+	defer ticker.Stop()
+	var fifo *os.File
+	for fifo == nil {
+		select {
+		case r := <-ch:
+			if r.err != nil {
+				return fmt.Errorf("failed to open exec fifo %v: %v", fifoPath, r.err)
+			}
+			fifo = r.file
+		case <-ticker.C:
+			if !util.IsProcessAlive(pid) {
+				return fmt.Errorf("init process %v exited before start", pid)
+			}
+		}
+	}
+	defer func() {
+		_ = fifo.Close()
+	}()
+	data, err := ioutil.ReadAll(fifo)
+	if err != nil {
+		return fmt.Errorf("failed to read exec fifo %v: %v", fifoPath, err)
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("init process %v exited before start", pid)
+	}
+	if err = os.Remove(fifoPath); err != nil {
+		logrus.Warningf("failed to remove exec fifo %v: %v", fifoPath, err)
+	}
+	return nil
+}
+
+// waitExecFifo 在 init 进程中执行：以只写方式打开 exec fifo，阻塞到 start 打开 fifo 为止
+func waitExecFifo() error {
+	fifo, err := os.OpenFile(fmt.Sprintf("/proc/self/fd/%d", initExecFifoFd), os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open exec fifo: %v", err)
+	}
+	defer func() {
+		_ = fifo.Close()
+	}()
+	if _, err = fifo.Write([]byte{0}); err != nil {
+		return fmt.Errorf("failed to write exec fifo: %v", err)
+	}
+	return nil
+}
+
+func generateExecFifoPath(containerName string) string {
+	return path.Join(generateMetadataDir(containerName), execFifoName)
+}
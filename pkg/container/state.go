@@ -0,0 +1,81 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wangao1236/my-runc/pkg/spec"
+)
+
+// OCI runtime-spec 中容器的状态，paused 是 runc 的扩展
+const (
+	ociStatusCreated = "created"
+	ociStatusRunning = "running"
+	ociStatusPaused  = "paused"
+	ociStatusStopped = "stopped"
+)
+
+// State 是 state 命令输出的容器状态，格式与 OCI runtime-spec 中的 state 相同，ID 为容器名称
+type State struct {
+	Version     string            `json:"ociVersion"`
+	ID          string            `json:"id"`
+	Status      string            `json:"status"`
+	PID         int               `json:"pid"`
+	Bundle      string            `json:"bundle"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// GetState 返回容器的 OCI 状态，init 进程已经退出或者 PID 已经被其他进程复用时为 stopped
+func GetState(containerName string) (*State, error) {
+	metadata, err := ReadMetadata(containerName)
+	if err != nil {
+		logrus.Errorf("failed to read metadata of %v: %v", containerName, err)
+		return nil, err
+	}
+	refreshMetadata(metadata)
+	state := &State{
+		Version:     spec.Version,
+		ID:          metadata.Name,
+		Status:      ociStatus(metadata.Status),
+		Bundle:      metadata.Bundle,
+		Annotations: metadata.Annotations,
+	}
+	if state.Status != ociStatusStopped {
+		state.PID = metadata.PID
+	}
+	return state, nil
+}
+
+// StateContainer 输出容器的 OCI 状态
+func StateContainer(containerName string) error {
+	state, err := GetState(containerName)
+	if err != nil {
+		return err
+	}
+	body, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		logrus.Errorf("failed to marshal %+v: %v", state, err)
+		return err
+	}
+	if _, err = fmt.Fprintln(os.Stdout, string(body)); err != nil {
+		logrus.Errorf("failed to print state of container %v: %v", containerName, err)
+		return err
+	}
+	return nil
+}
+
+// ociStatus 将元数据中的状态转换为 OCI 状态，已退出、已停止和等待重启的容器都是 stopped
+func ociStatus(status string) string {
+	switch status {
+	case StatusCreated:
+		return ociStatusCreated
+	case StatusRunning:
+		return ociStatusRunning
+	case StatusPaused:
+		return ociStatusPaused
+	default:
+		return ociStatusStopped
+	}
+}
@@ -0,0 +1,21 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOCIStatus(t *testing.T) {
+	cases := map[string]string{
+		StatusCreated:    ociStatusCreated,
+		StatusRunning:    ociStatusRunning,
+		StatusPaused:     ociStatusPaused,
+		StatusStopped:    ociStatusStopped,
+		StatusExited:     ociStatusStopped,
+		StatusRestarting: ociStatusStopped,
+	}
+	for status, expected := range cases {
+		assert.Equal(t, expected, ociStatus(status), status)
+	}
+}
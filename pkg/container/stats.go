@@ -0,0 +1,262 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wangao1236/my-runc/pkg/cgroup"
+	"github.com/wangao1236/my-runc/pkg/util"
+)
+
+const (
+	StatsFormatTable = "table"
+	StatsFormatJSON  = "json"
+
+	statsInterval = time.Second
+)
+
+// Stats 表示某一时刻容器的资源使用情况
+type Stats struct {
+	ID            string  `json:"id"`
+	Name          string  `json:"name"`
+	CPUPercent    float64 `json:"cpuPercent"`
+	MemoryUsage   uint64  `json:"memoryUsage"`
+	MemoryLimit   uint64  `json:"memoryLimit"`
+	MemoryPercent float64 `json:"memoryPercent"`
+	NetworkRx     uint64  `json:"networkRx"`
+	NetworkTx     uint64  `json:"networkTx"`
+	BlockRead     uint64  `json:"blockRead"`
+	BlockWrite    uint64  `json:"blockWrite"`
+	Pids          uint64  `json:"pids"`
+	// Pressure 和 MemoryEvents 只在 cgroup v2 下提供
+	Pressure     *cgroup.PressureStats `json:"pressure,omitempty"`
+	MemoryEvents *cgroup.MemoryEvents  `json:"memoryEvents,omitempty"`
+
+	cpuUsage uint64
+	read     time.Time
+}
+
+// StatsContainers 周期性地输出容器的资源使用情况，containerNames 为空时输出所有运行中和已挂起的容器。
+// CPU 使用率需要两次采样才能计算，因此 noStream 时也会等待一个采样周期后再输出。
+// psi 为 true 时表格中输出 CPU、内存、IO 的压力和 memory.events，而不是资源使用量，只在 cgroup v2 下支持
+func StatsContainers(containerNames []string, noStream, psi bool, format string) error {
+	if format != StatsFormatTable && format != StatsFormatJSON {
+		return fmt.Errorf("unsupported format %v, only %v and %v are supported",
+			format, StatsFormatTable, StatsFormatJSON)
+	}
+	if psi && !util.IsCgroup2UnifiedMode() {
+		return fmt.Errorf("pressure stall information is only available on cgroup v2")
+	}
+	all := len(containerNames) == 0
+	previous := make(map[string]*Stats)
+	if _, err := sampleStats(containerNames, all, previous); err != nil {
+		return err
+	}
+	for {
+		time.Sleep(statsInterval)
+		current, err := sampleStats(containerNames, all, previous)
+		if err != nil {
+			return err
+		}
+		if err = printStats(current, format, psi, !noStream); err != nil {
+			return err
+		}
+		if noStream {
+			return nil
+		}
+	}
+}
+
+// sampleStats 对容器进行一次采样，并根据 previous 中上一次的采样结果计算 CPU 使用率
+func sampleStats(containerNames []string, all bool, previous map[string]*Stats) ([]*Stats, error) {
+	names, err := statsTargets(containerNames, all)
+	if err != nil {
+		return nil, err
+	}
+	var current []*Stats
+	for _, name := range names {
+		var stats *Stats
+		if stats, err = collectStats(name); err != nil {
+			if all {
+				// 容器可能在两次采样之间退出
+				logrus.Warningf("failed to collect stats of container %v: %v", name, err)
+				continue
+			}
+			return nil, err
+		}
+		if prev, ok := previous[name]; ok {
+			stats.CPUPercent = calculateCPUPercent(prev, stats)
+		}
+		previous[name] = stats
+		current = append(current, stats)
+	}
+	return current, nil
+}
+
+// statsTargets 返回需要采样的容器名称
+func statsTargets(containerNames []string, all bool) ([]string, error) {
+	if !all {
+		return containerNames, nil
+	}
+	files, err := ioutil.ReadDir(metadataRootDir)
+	if err != nil {
+		logrus.Errorf("failed to read directory (%v): %v", metadataRootDir, err)
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		metadata, readErr := ReadMetadata(file.Name())
+		if readErr != nil || (metadata.Status != StatusRunning && metadata.Status != StatusPaused) {
+			continue
+		}
+		names = append(names, file.Name())
+	}
+	return names, nil
+}
+
+// collectStats 从 cgroup 和 veth 设备中读取容器的资源使用情况
+func collectStats(containerName string) (*Stats, error) {
+	metadata, err := ReadMetadata(containerName)
+	if err != nil {
+		logrus.Errorf("failed to read metadata of %v: %v", containerName, err)
+		return nil, err
+	}
+	if len(metadata.CgroupPath) == 0 {
+		return nil, fmt.Errorf("cgroup path of container %v is unknown", containerName)
+	}
+	cgroupStats, err := metadata.CgroupManager().GetStats()
+	if err != nil {
+		logrus.Errorf("failed to get stats of cgroup %v: %v", metadata.CgroupPath, err)
+		return nil, err
+	}
+
+	stats := &Stats{
+		ID:          metadata.ID,
+		Name:        metadata.Name,
+		MemoryUsage: cgroupStats.Memory.Usage,
+		MemoryLimit: cgroupStats.Memory.Limit,
+		BlockRead:   cgroupStats.Blkio.ReadBytes,
+		BlockWrite:  cgroupStats.Blkio.WriteBytes,
+		Pids:        cgroupStats.Pids.Current,
+		cpuUsage:    cgroupStats.CPU.UsageNanos,
+		read:        time.Now(),
+	}
+	stats.Pressure, stats.MemoryEvents = cgroupStats.Pressure, cgroupStats.Memory.Events
+	stats.MemoryLimit = effectiveMemoryLimit(stats.MemoryLimit, hostMemoryTotal())
+	if stats.MemoryLimit > 0 {
+		stats.MemoryPercent = float64(stats.MemoryUsage) / float64(stats.MemoryLimit) * 100
+	}
+
+	// 宿主机一端 veth 设备的接收即为容器的发送
+	for _, endpoint := range metadata.Endpoints {
+		if endpoint == nil {
+			continue
+		}
+		linkStats, statsErr := util.GetInterfaceStatistics(endpoint.HostVethName())
+		if statsErr != nil {
+			logrus.Warningf("failed to get statistics of endpoint %v: %v", endpoint.ID, statsErr)
+			continue
+		}
+		stats.NetworkRx += linkStats.TxBytes
+		stats.NetworkTx += linkStats.RxBytes
+	}
+	return stats, nil
+}
+
+// calculateCPUPercent 根据两次采样之间的 CPU 时间计算使用率，100% 表示占满一个 CPU
+func calculateCPUPercent(prev, current *Stats) float64 {
+	elapsed := current.read.Sub(prev.read).Nanoseconds()
+	if elapsed <= 0 || current.cpuUsage < prev.cpuUsage {
+		return 0
+	}
+	return float64(current.cpuUsage-prev.cpuUsage) / float64(elapsed) * 100
+}
+
+// effectiveMemoryLimit 返回容器实际的内存上限：没有内存限制时，cgroup v2 返回 0，cgroup v1 返回一个很大的数，
+// 此时以宿主机的内存总量 total 作为上限，total 为 0 表示无法获取宿主机的内存总量
+func effectiveMemoryLimit(limit, total uint64) uint64 {
+	if total > 0 && (limit == 0 || limit > total) {
+		return total
+	}
+	return limit
+}
+
+func hostMemoryTotal() uint64 {
+	var info syscall.Sysinfo_t
+	if err := syscall.Sysinfo(&info); err != nil {
+		logrus.Warningf("failed to get sysinfo: %v", err)
+		return 0
+	}
+	return info.Totalram * uint64(info.Unit)
+}
+
+func printStats(stats []*Stats, format string, psi, clear bool) error {
+	if format == StatsFormatJSON {
+		body, err := json.Marshal(stats)
+		if err != nil {
+			logrus.Errorf("failed to marshal stats: %v", err)
+			return err
+		}
+		_, err = fmt.Fprintln(os.Stdout, string(body))
+		return err
+	}
+
+	if clear {
+		// 清屏并将光标移动到左上角，实现刷新的效果
+		_, _ = fmt.Fprint(os.Stdout, "\033[2J\033[H")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	if psi {
+		printPressure(w, stats)
+		if err := w.Flush(); err != nil {
+			return fmt.Errorf("flush stats write err: %v", err)
+		}
+		return nil
+	}
+	_, _ = fmt.Fprint(w, "ID\tNAME\tCPU %\tMEM USAGE / LIMIT\tMEM %\tNET I/O\tBLOCK I/O\tPIDS\n")
+	for _, s := range stats {
+		_, _ = fmt.Fprintf(w, "%v\t%v\t%.2f%%\t%v / %v\t%.2f%%\t%v / %v\t%v / %v\t%v\n", s.ID, s.Name, s.CPUPercent,
+			util.HumanSize(s.MemoryUsage), util.HumanSize(s.MemoryLimit), s.MemoryPercent,
+			util.HumanSize(s.NetworkRx), util.HumanSize(s.NetworkTx),
+			util.HumanSize(s.BlockRead), util.HumanSize(s.BlockWrite), s.Pids)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("flush stats write err: %v", err)
+	}
+	return nil
+}
+
+// printPressure 输出每个容器最近 10s 内的压力（some / full）和 memory.events 中 high / max / oom / oom_kill 的计数
+func printPressure(w *tabwriter.Writer, stats []*Stats) {
+	_, _ = fmt.Fprint(w, "ID\tNAME\tCPU PSI\tMEM PSI\tIO PSI\tMEM EVENTS (HIGH / MAX / OOM / OOM KILL)\n")
+	for _, s := range stats {
+		var cpu, memory, io *cgroup.PSIStats
+		if s.Pressure != nil {
+			cpu, memory, io = s.Pressure.CPU, s.Pressure.Memory, s.Pressure.IO
+		}
+		events := "-"
+		if s.MemoryEvents != nil {
+			events = fmt.Sprintf("%v / %v / %v / %v", s.MemoryEvents.High, s.MemoryEvents.Max,
+				s.MemoryEvents.OOM, s.MemoryEvents.OOMKill)
+		}
+		_, _ = fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", s.ID, s.Name,
+			formatPSI(cpu), formatPSI(memory), formatPSI(io), events)
+	}
+}
+
+// formatPSI 将压力格式化为 "some% / full%"，内核没有提供时输出 "-"
+func formatPSI(psi *cgroup.PSIStats) string {
+	if psi == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f%% / %.2f%%", psi.Some.Avg10, psi.Full.Avg10)
+}
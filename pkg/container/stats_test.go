@@ -0,0 +1,73 @@
+package container
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalculateCPUPercent(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		prev    *Stats
+		current *Stats
+		percent float64
+	}{
+		{
+			name:    "half of one cpu",
+			prev:    &Stats{cpuUsage: 1000000000, read: now},
+			current: &Stats{cpuUsage: 1500000000, read: now.Add(time.Second)},
+			percent: 50,
+		},
+		{
+			name:    "two cpus",
+			prev:    &Stats{cpuUsage: 0, read: now},
+			current: &Stats{cpuUsage: 1000000000, read: now.Add(500 * time.Millisecond)},
+			percent: 200,
+		},
+		{
+			name:    "idle",
+			prev:    &Stats{cpuUsage: 1000, read: now},
+			current: &Stats{cpuUsage: 1000, read: now.Add(time.Second)},
+			percent: 0,
+		},
+		{
+			// 容器重启后 cgroup 的 CPU 时间重新计数
+			name:    "usage decreased",
+			prev:    &Stats{cpuUsage: 2000000000, read: now},
+			current: &Stats{cpuUsage: 1000, read: now.Add(time.Second)},
+			percent: 0,
+		},
+		{
+			name:    "no time elapsed",
+			prev:    &Stats{cpuUsage: 1000, read: now},
+			current: &Stats{cpuUsage: 2000, read: now},
+			percent: 0,
+		},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.percent, calculateCPUPercent(test.prev, test.current), test.name)
+	}
+}
+
+func TestEffectiveMemoryLimit(t *testing.T) {
+	const total = 8 << 30
+	tests := []struct {
+		name   string
+		limit  uint64
+		total  uint64
+		result uint64
+	}{
+		{name: "limited", limit: 100 << 20, total: total, result: 100 << 20},
+		{name: "unlimited on cgroup v2", limit: 0, total: total, result: total},
+		{name: "unlimited on cgroup v1", limit: 9223372036854771712, total: total, result: total},
+		{name: "limit equals host memory", limit: total, total: total, result: total},
+		{name: "unknown host memory", limit: 0, total: 0, result: 0},
+		{name: "unknown host memory with limit", limit: 100 << 20, total: 0, result: 100 << 20},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.result, effectiveMemoryLimit(test.limit, test.total), test.name)
+	}
+}
@@ -0,0 +1,172 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// syncMountsReady 表示 init 进程已经完成 pivot_root 和挂载
+	syncMountsReady = "mounts-ready"
+	// syncExecReady 表示 init 进程已经完成全部初始化，正在 exec fifo 上等待 start
+	syncExecReady = "exec-ready"
+	// syncError 表示 init 进程初始化失败，Message 是失败的原因
+	syncError = "error"
+)
+
+// syncMessage 是父进程与容器 init 进程之间通过 sync socket 传递的消息，每条消息是一个 JSON 对象
+type syncMessage struct {
+	Type    string `json:"type"`
+	Message string `json:"message,omitempty"`
+}
+
+// InitPipe 是父进程与容器 init 进程之间的通信通道：
+// 1. 配置管道中是 4 字节大端序的长度加上 JSON 格式的 InitConfig；
+// 2. sync socket 用于 init 进程报告初始化的进度和错误；
+// 3. exec fifo 用于阻塞 init 进程，直到 start 打开 fifo 之后才执行用户命令。
+// sync socket 在 init 进程中是 close-on-exec 的，用户命令执行成功后父进程读到 EOF
+type InitPipe struct {
+	containerName string
+	config        *os.File
+	childConfig   *os.File
+	sync          *os.File
+	childSync     *os.File
+	execFifo      *os.File
+	decoder       *json.Decoder
+}
+
+// newInitPipe 创建配置管道、sync socket 和容器的 exec fifo
+func newInitPipe(containerName string) (*InitPipe, error) {
+	childConfig, config, err := newPipe()
+	if err != nil {
+		return nil, err
+	}
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		logrus.Errorf("failed to create sync socket: %v", err)
+		_ = childConfig.Close()
+		_ = config.Close()
+		return nil, err
+	}
+	p := &InitPipe{
+		containerName: containerName,
+		config:        config,
+		childConfig:   childConfig,
+		sync:          os.NewFile(uintptr(fds[0]), "sync-socket"),
+		childSync:     os.NewFile(uintptr(fds[1]), "sync-socket-child"),
+	}
+	p.decoder = json.NewDecoder(p.sync)
+	if p.execFifo, err = createExecFifo(containerName); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// ExtraFiles 返回需要传给容器 init 进程的文件，顺序与 initConfigFd、initSyncFd、initExecFifoFd 对应
+func (p *InitPipe) ExtraFiles() []*os.File {
+	return []*os.File{p.childConfig, p.childSync, p.execFifo}
+}
+
+// Send 在容器 init 进程启动之后发送配置，并等待 init 进程完成初始化，返回 init 进程报告的错误。
+// 返回 nil 时 init 进程正在 exec fifo 上等待 start
+func (p *InitPipe) Send(config *InitConfig) error {
+	// 关闭父进程中 init 进程一端的文件，否则 init 进程退出后父进程无法读到 EOF
+	_ = p.childConfig.Close()
+	_ = p.childSync.Close()
+	_ = p.execFifo.Close()
+
+	config.Version = InitConfigVersion
+	err := writeInitConfig(p.config, config)
+	if closeErr := p.config.Close(); closeErr != nil {
+		logrus.Warningf("failed to close config pipe: %v", closeErr)
+	}
+	if err != nil {
+		logrus.Errorf("failed to send init config: %v", err)
+		return err
+	}
+	if err = p.expect(syncMountsReady); err != nil {
+		return err
+	}
+	logrus.Infof("mounts of container are ready")
+	return p.expect(syncExecReady)
+}
+
+// Start 打开 exec fifo 使 init 进程继续执行用户命令，不修改容器的状态，用于监控进程重启容器
+func (p *InitPipe) Start(pid int) error {
+	return startInit(p.containerName, pid)
+}
+
+// WaitExec 在 start 之后等待 init 进程执行用户命令，返回执行失败的原因。
+// 容器没有 start 时会一直阻塞，init 进程没有执行用户命令就退出时返回 nil
+func (p *InitPipe) WaitExec() error {
+	defer func() {
+		_ = p.sync.Close()
+	}()
+	// 用户命令执行成功后 sync socket 随 execve 关闭
+	msg := &syncMessage{}
+	if err := p.decoder.Decode(msg); err == io.EOF {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read from sync socket: %v", err)
+	}
+	if msg.Type == syncError {
+		return fmt.Errorf("%v", msg.Message)
+	}
+	return fmt.Errorf("unexpected message %v from init process", msg.Type)
+}
+
+// Close 关闭所有文件，用于 init 进程没有启动成功或者初始化失败的情况
+func (p *InitPipe) Close() {
+	for _, f := range []*os.File{p.config, p.childConfig, p.sync, p.childSync, p.execFifo} {
+		if f != nil {
+			_ = f.Close()
+		}
+	}
+}
+
+// expect 读取 init 进程的下一条消息，init 进程报告错误或者提前退出时返回错误
+func (p *InitPipe) expect(syncType string) error {
+	msg := &syncMessage{}
+	if err := p.decoder.Decode(msg); err == io.EOF {
+		return fmt.Errorf("init process exited before %v", syncType)
+	} else if err != nil {
+		return fmt.Errorf("failed to read from sync socket: %v", err)
+	}
+	switch msg.Type {
+	case syncType:
+		return nil
+	case syncError:
+		return fmt.Errorf("%v", msg.Message)
+	default:
+		return fmt.Errorf("unexpected message %v from init process, expect %v", msg.Type, syncType)
+	}
+}
+
+// initSync 是容器 init 进程一端的 sync socket
+type initSync struct {
+	file *os.File
+}
+
+func newInitSync() *initSync {
+	return &initSync{file: os.NewFile(uintptr(initSyncFd), "sync-socket")}
+}
+
+func (s *initSync) send(syncType, message string) error {
+	if err := json.NewEncoder(s.file).Encode(&syncMessage{Type: syncType, Message: message}); err != nil {
+		return fmt.Errorf("failed to send %v to parent process: %v", syncType, err)
+	}
+	return nil
+}
+
+// reportError 将初始化失败的原因报告给父进程
+func (s *initSync) reportError(err error) {
+	if sendErr := s.send(syncError, err.Error()); sendErr != nil {
+		logrus.Warningf("failed to report error to parent process: %v", sendErr)
+	}
+}
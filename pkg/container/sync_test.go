@@ -0,0 +1,48 @@
+package container
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestInitPipe(t *testing.T) {
+	defer useTempMetadataRootDir(t)()
+	p, err := newInitPipe("test-init-pipe")
+	assert.Equal(t, nil, err)
+	// 模拟 init 进程：Send 会关闭父进程中 init 进程一端的文件，这里使用复制的文件描述符
+	childConfig, childSync := dupFile(t, p.childConfig), dupFile(t, p.childSync)
+	go func() {
+		config, readErr := readInitConfig(childConfig)
+		assert.Equal(t, nil, readErr)
+		assert.Equal(t, []string{"echo", "a b"}, config.Args)
+		encoder := json.NewEncoder(childSync)
+		_ = encoder.Encode(&syncMessage{Type: syncMountsReady})
+		_ = encoder.Encode(&syncMessage{Type: syncError, Message: "no such file"})
+		_ = childSync.Close()
+	}()
+	err = p.Send(&InitConfig{Args: []string{"echo", "a b"}})
+	assert.Equal(t, "no such file", err.Error())
+	p.Close()
+}
+
+// useTempMetadataRootDir 将元数据目录替换为临时目录，返回恢复并删除临时目录的函数
+func useTempMetadataRootDir(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "my-runc-containers")
+	assert.Equal(t, nil, err)
+	metadataRootDir = dir
+	return func() {
+		metadataRootDir = DefaultMetadataRootDir
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func dupFile(t *testing.T, f *os.File) *os.File {
+	fd, err := unix.Dup(int(f.Fd()))
+	assert.Equal(t, nil, err)
+	return os.NewFile(uintptr(fd), f.Name())
+}
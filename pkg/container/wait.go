@@ -0,0 +1,94 @@
+package container
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wangao1236/my-runc/pkg/util"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	waitInterval = 100 * time.Millisecond
+	// exitRecordTimeout 是容器进程退出后等待监控进程记录退出状态的最长时间
+	exitRecordTimeout = 5 * time.Second
+	// DefaultStopTimeout 是 stop 命令发送 stop signal 之后等待容器退出的默认时间
+	DefaultStopTimeout = 10 * time.Second
+	// killTimeout 是发送 SIGKILL 之后等待容器退出的最长时间
+	killTimeout = 10 * time.Second
+)
+
+// WaitContainer 阻塞直到容器退出，返回监控进程记录的退出码，调用者不需要是启动容器的进程。
+// 容器按照重启策略重启时会继续等待，直到容器不再重启
+func WaitContainer(containerName string) (int, error) {
+	// 容器进程退出后，监控进程才会将退出状态写入元数据
+	deadline := time.Now().Add(exitRecordTimeout)
+	for {
+		metadata, err := ReadMetadata(containerName)
+		if err != nil {
+			logrus.Errorf("failed to read metadata of %v: %v", containerName, err)
+			return -1, err
+		}
+		switch {
+		case (metadata.Status == StatusCreated || metadata.Status == StatusRunning || metadata.Status == StatusPaused) &&
+			metadata.isProcessAlive():
+			if _, err = waitProcess(metadata.PID, -1); err != nil {
+				logrus.Errorf("failed to wait process %v of container %v: %v", metadata.PID, containerName, err)
+				return -1, err
+			}
+			deadline = time.Now().Add(exitRecordTimeout)
+			continue
+		case metadata.Status == StatusRestarting:
+			deadline = time.Now().Add(exitRecordTimeout)
+		case metadata.FinishedAt != nil && (metadata.Status == StatusExited || metadata.Status == StatusStopped):
+			return metadata.ExitCode, nil
+		}
+		if time.Now().After(deadline) {
+			return -1, fmt.Errorf("exit code of container %v is unknown, it is not started by a monitor process",
+				containerName)
+		}
+		time.Sleep(waitInterval)
+	}
+}
+
+// waitProcess 阻塞直到 pid 对应的进程退出或超过 timeout，timeout 为负数时一直等待，返回进程是否已经退出。
+// 优先使用 pidfd（5.3 以上的内核），进程退出时 pidfd 变为可读，不支持 pidfd 时轮询进程是否存在
+func waitProcess(pid int, timeout time.Duration) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	fd, err := unix.PidfdOpen(pid, 0)
+	if err == unix.ESRCH {
+		return true, nil
+	}
+	if err != nil {
+		logrus.Warningf("failed to open pidfd of process %v, fall back to polling: %v", pid, err)
+		for util.IsProcessAlive(pid) {
+			if timeout >= 0 && time.Now().After(deadline) {
+				return false, nil
+			}
+			time.Sleep(waitInterval)
+		}
+		return true, nil
+	}
+	defer func() {
+		_ = unix.Close(fd)
+	}()
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	for {
+		ms := -1
+		if timeout >= 0 {
+			ms = int(time.Until(deadline) / time.Millisecond)
+			if ms < 0 {
+				ms = 0
+			}
+		}
+		var n int
+		if n, err = unix.Poll(fds, ms); err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		return n > 0, nil
+	}
+}
@@ -20,7 +20,12 @@ func CreateWorkspace(rootDir, imageTar, containerName string, volumes []string)
 		logrus.Errorf("failed to create readonly layer: %v", err)
 		return "", "", "", err
 	}
+	return CreateWorkspaceFromRootfs(rootDir, readonlyPath, containerName, volumes)
+}
 
+// CreateWorkspaceFromRootfs 以 rootfs 目录（如 OCI bundle 中的 rootfs）作为只读层创建 workspace，容器的修改只写入可读写层
+func CreateWorkspaceFromRootfs(rootDir, rootfs, containerName string, volumes []string) (
+	writeLayer, workLayer, workspace string, err error) {
 	writeLayer, err = createWriteLayer(rootDir, containerName)
 	if err != nil {
 		logrus.Errorf("failed to create write layer: %v", err)
@@ -33,7 +38,7 @@ func CreateWorkspace(rootDir, imageTar, containerName string, volumes []string)
 		return "", "", "", err
 	}
 
-	workspace, err = createWorkspace(rootDir, containerName, readonlyPath, writeLayer, workLayer)
+	workspace, err = createWorkspace(rootDir, containerName, rootfs, writeLayer, workLayer)
 	if err != nil {
 		logrus.Errorf("failed to create mount point: %v", err)
 		return "", "", "", err
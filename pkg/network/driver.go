@@ -105,7 +105,7 @@ func (bd *bridgeDriver) Connect(network *Network, endpoint *types.Endpoint) erro
 
 	// 创建 veth pair 的配置
 	la := netlink.NewLinkAttrs()
-	la.Name = endpoint.ID[:5]
+	la.Name = endpoint.HostVethName()
 	// 相当于执行 `ip link set dev ${peer-name} master ${bridge-name}`，将 veth pair 的 peer 端插在 bridge 上
 	la.MasterIndex = br.Attrs().Index
 	// 初始化 veth pair 设备的基本配置
@@ -188,13 +188,53 @@ func Connect(networkName string, portMappings map[int]int, metadata *container.M
 	logrus.Infof("succeeded in setting network for container (%v)->(%v)", metadata, network)
 
 	metadata.Endpoints = append(metadata.Endpoints, endpoint)
-	if err = container.SaveMetadata(metadata); err != nil {
+	if _, err = container.UpdateMetadata(metadata.Name, func(latest *container.Metadata) bool {
+		latest.PortMappings = metadata.PortMappings
+		latest.Endpoints = metadata.Endpoints
+		return true
+	}); err != nil {
 		logrus.Errorf("failed to update endpoints in metadata of container (%v)", metadata)
 		return err
 	}
 	return nil
 }
 
+// Reconnect 在容器重启之后重新连接容器原有的 endpoint：容器的 netns 随 init 进程退出而销毁，其中的 veth pair 也会被删除，
+// 这里使用 endpoint 已经分配的 IP 重新创建 veth pair，端口映射的 iptables 规则只与 IP 有关，不需要重新设置
+func Reconnect(metadata *container.Metadata) error {
+	for _, endpoint := range metadata.Endpoints {
+		if endpoint == nil {
+			continue
+		}
+		network, err := readNetwork(endpoint.Network)
+		if err != nil {
+			logrus.Errorf("failed to get network (%v): %v", endpoint.Network, err)
+			return err
+		}
+		if _, ok := drivers[network.Driver]; !ok {
+			logrus.Errorf("do not support driver %v", network.Driver)
+			return fmt.Errorf("do not support driver %v", network.Driver)
+		}
+
+		// 旧的 netns 可能还没有被内核回收，先删除残留的 veth pair
+		if link, linkErr := netlink.LinkByName(endpoint.HostVethName()); linkErr == nil {
+			if err = netlink.LinkDel(link); err != nil {
+				logrus.Warningf("failed to delete stale veth pair (%v): %v", endpoint.HostVethName(), err)
+			}
+		}
+		if err = drivers[network.Driver].Connect(network, endpoint); err != nil {
+			logrus.Errorf("failed to connect %v for container (%v): %v", network, metadata, err)
+			return err
+		}
+		if err = setContainerNetwork(endpoint, metadata); err != nil {
+			logrus.Errorf("failed to set network for container (%v): %v", metadata, err)
+			return err
+		}
+		logrus.Infof("succeeded in reconnecting endpoint (%v) for container (%v)", endpoint.ID, metadata.Name)
+	}
+	return nil
+}
+
 func Disconnect(metadata *container.Metadata) error {
 	var errs []error
 	for i, ep := range metadata.Endpoints {
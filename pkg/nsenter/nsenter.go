@@ -30,11 +30,19 @@ __attribute__((constructor)) static void enter_namespace() {
         return;
     }
 
+    // 等待父进程将当前进程加入容器的 cgroup，此时 Go runtime 还没有启动，进程只有一个线程
+    char ready;
+    if (read(3, &ready, 1) != 1) {
+        fprintf(stderr, "failed to wait for joining cgroup of the container\n");
+        exit(1);
+    }
+    close(3);
+
     int i;
     char nsPath[1024];
-    char *namespaces[] = {"ipc", "uts", "net", "pid", "mnt"};
+    char *namespaces[] = {"ipc", "uts", "net", "pid", "cgroup", "mnt"};
 
-    for (i = 0; i < 5; i++) {
+    for (i = 0; i < 6; i++) {
         sprintf(nsPath, "/proc/%s/ns/%s", my_docker_pid, namespaces[i]);
         fprintf(stdout, "try to join namespace %s\n", nsPath);
         int fd = open(nsPath, O_RDONLY);
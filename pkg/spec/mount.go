@@ -0,0 +1,73 @@
+package spec
+
+import (
+	"strings"
+	"syscall"
+)
+
+type mountFlag struct {
+	clear bool
+	flag  uintptr
+}
+
+// mountFlags 是 mount 选项中对应挂载标志位的部分，clear 为 true 表示清除该标志位
+var mountFlags = map[string]mountFlag{
+	"async":         {true, syscall.MS_SYNCHRONOUS},
+	"atime":         {true, syscall.MS_NOATIME},
+	"bind":          {false, syscall.MS_BIND},
+	"defaults":      {false, 0},
+	"dev":           {true, syscall.MS_NODEV},
+	"diratime":      {true, syscall.MS_NODIRATIME},
+	"dirsync":       {false, syscall.MS_DIRSYNC},
+	"exec":          {true, syscall.MS_NOEXEC},
+	"mand":          {false, syscall.MS_MANDLOCK},
+	"noatime":       {false, syscall.MS_NOATIME},
+	"nodev":         {false, syscall.MS_NODEV},
+	"nodiratime":    {false, syscall.MS_NODIRATIME},
+	"noexec":        {false, syscall.MS_NOEXEC},
+	"nomand":        {true, syscall.MS_MANDLOCK},
+	"norelatime":    {true, syscall.MS_RELATIME},
+	"nostrictatime": {true, syscall.MS_STRICTATIME},
+	"nosuid":        {false, syscall.MS_NOSUID},
+	"rbind":         {false, syscall.MS_BIND | syscall.MS_REC},
+	"relatime":      {false, syscall.MS_RELATIME},
+	"ro":            {false, syscall.MS_RDONLY},
+	"rw":            {true, syscall.MS_RDONLY},
+	"strictatime":   {false, syscall.MS_STRICTATIME},
+	"suid":          {true, syscall.MS_NOSUID},
+	"sync":          {false, syscall.MS_SYNCHRONOUS},
+}
+
+// propagationOptions 是挂载传播类型的选项，容器的根目录已经是 rprivate 的，这些选项会被忽略
+var propagationOptions = map[string]bool{
+	"private":     true,
+	"rprivate":    true,
+	"shared":      true,
+	"rshared":     true,
+	"slave":       true,
+	"rslave":      true,
+	"unbindable":  true,
+	"runbindable": true,
+}
+
+// ParseMountOptions 将 mount 选项解析为 mount 系统调用的标志位和 data，如 ["nosuid", "ro", "mode=755"]
+// 解析为 MS_NOSUID|MS_RDONLY 和 "mode=755"
+func ParseMountOptions(options []string) (uintptr, string) {
+	var flags uintptr
+	var data []string
+	for _, option := range options {
+		if f, ok := mountFlags[option]; ok {
+			if f.clear {
+				flags &^= f.flag
+			} else {
+				flags |= f.flag
+			}
+			continue
+		}
+		if propagationOptions[option] {
+			continue
+		}
+		data = append(data, option)
+	}
+	return flags, strings.Join(data, ",")
+}
@@ -0,0 +1,271 @@
+package spec
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+const (
+	// Version 是支持的 OCI runtime-spec 版本，config.json 中的 ociVersion 需要与其主版本号相同
+	Version = "1.0.2"
+
+	// ConfigName 是 bundle 中配置文件的名称
+	ConfigName = "config.json"
+	// DefaultRootfs 是 spec 命令生成的配置中 rootfs 的路径，相对于 bundle 目录
+	DefaultRootfs = "rootfs"
+)
+
+// 以下是 linux.namespaces 中支持的 namespace 类型
+const (
+	PIDNamespace     = "pid"
+	NetworkNamespace = "network"
+	MountNamespace   = "mount"
+	IPCNamespace     = "ipc"
+	UTSNamespace     = "uts"
+	UserNamespace    = "user"
+	CgroupNamespace  = "cgroup"
+)
+
+// Spec 是 OCI runtime-spec 中 config.json 的结构，只包含 my-runc 支持的字段，其余字段在解析时被忽略
+type Spec struct {
+	Version     string            `json:"ociVersion"`
+	Process     *Process          `json:"process,omitempty"`
+	Root        *Root             `json:"root,omitempty"`
+	Hostname    string            `json:"hostname,omitempty"`
+	Mounts      []Mount           `json:"mounts,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Linux       *Linux            `json:"linux,omitempty"`
+}
+
+// Process 是容器中执行的用户命令
+type Process struct {
+	// Terminal 为 true 时容器的标准输入输出连接到当前终端
+	Terminal bool          `json:"terminal,omitempty"`
+	User     User          `json:"user"`
+	Args     []string      `json:"args,omitempty"`
+	Env      []string      `json:"env,omitempty"`
+	Cwd      string        `json:"cwd"`
+	Rlimits  []POSIXRlimit `json:"rlimits,omitempty"`
+}
+
+// User 是执行用户命令的用户，ID 是容器内（user namespace 中）的 ID
+type User struct {
+	UID            uint32   `json:"uid"`
+	GID            uint32   `json:"gid"`
+	AdditionalGids []uint32 `json:"additionalGids,omitempty"`
+}
+
+// POSIXRlimit 是用户命令的资源限制，Type 形如 RLIMIT_NOFILE
+type POSIXRlimit struct {
+	Type string `json:"type"`
+	Hard uint64 `json:"hard"`
+	Soft uint64 `json:"soft"`
+}
+
+// Root 是容器的根文件系统，Path 为相对路径时相对于 bundle 目录
+type Root struct {
+	Path     string `json:"path"`
+	Readonly bool   `json:"readonly,omitempty"`
+}
+
+// Mount 是容器内的一次挂载，bind mount 的 Source 为相对路径时相对于 bundle 目录
+type Mount struct {
+	Destination string   `json:"destination"`
+	Type        string   `json:"type,omitempty"`
+	Source      string   `json:"source,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// Linux 是 Linux 平台特有的配置
+type Linux struct {
+	UIDMappings []LinuxIDMapping `json:"uidMappings,omitempty"`
+	GIDMappings []LinuxIDMapping `json:"gidMappings,omitempty"`
+	Resources   *LinuxResources  `json:"resources,omitempty"`
+	Namespaces  []LinuxNamespace `json:"namespaces,omitempty"`
+}
+
+// LinuxNamespace 是容器使用的 namespace，Path 不为空时表示加入已有的 namespace
+type LinuxNamespace struct {
+	Type string `json:"type"`
+	Path string `json:"path,omitempty"`
+}
+
+// LinuxIDMapping 将容器内从 ContainerID 开始的 Size 个 ID 映射到宿主机上从 HostID 开始的 ID
+type LinuxIDMapping struct {
+	ContainerID uint32 `json:"containerID"`
+	HostID      uint32 `json:"hostID"`
+	Size        uint32 `json:"size"`
+}
+
+// LinuxResources 是容器 cgroup 的资源限制
+type LinuxResources struct {
+	Devices        []LinuxDeviceCgroup  `json:"devices,omitempty"`
+	Memory         *LinuxMemory         `json:"memory,omitempty"`
+	CPU            *LinuxCPU            `json:"cpu,omitempty"`
+	Pids           *LinuxPids           `json:"pids,omitempty"`
+	BlockIO        *LinuxBlockIO        `json:"blockIO,omitempty"`
+	HugepageLimits []LinuxHugepageLimit `json:"hugepageLimits,omitempty"`
+}
+
+// LinuxDeviceCgroup 是一条设备访问规则，Major 或 Minor 为空时匹配所有设备号
+type LinuxDeviceCgroup struct {
+	Allow  bool   `json:"allow"`
+	Type   string `json:"type,omitempty"`
+	Major  *int64 `json:"major,omitempty"`
+	Minor  *int64 `json:"minor,omitempty"`
+	Access string `json:"access,omitempty"`
+}
+
+type LinuxMemory struct {
+	Limit            *int64 `json:"limit,omitempty"`
+	Swap             *int64 `json:"swap,omitempty"`
+	DisableOOMKiller *bool  `json:"disableOOMKiller,omitempty"`
+}
+
+type LinuxCPU struct {
+	Shares *uint64 `json:"shares,omitempty"`
+	Quota  *int64  `json:"quota,omitempty"`
+	Period *uint64 `json:"period,omitempty"`
+	Cpus   string  `json:"cpus,omitempty"`
+	Mems   string  `json:"mems,omitempty"`
+}
+
+type LinuxPids struct {
+	Limit int64 `json:"limit"`
+}
+
+type LinuxBlockIO struct {
+	Weight                  *uint16               `json:"weight,omitempty"`
+	ThrottleReadBpsDevice   []LinuxThrottleDevice `json:"throttleReadBpsDevice,omitempty"`
+	ThrottleWriteBpsDevice  []LinuxThrottleDevice `json:"throttleWriteBpsDevice,omitempty"`
+	ThrottleReadIOPSDevice  []LinuxThrottleDevice `json:"throttleReadIOPSDevice,omitempty"`
+	ThrottleWriteIOPSDevice []LinuxThrottleDevice `json:"throttleWriteIOPSDevice,omitempty"`
+}
+
+type LinuxThrottleDevice struct {
+	Major int64  `json:"major"`
+	Minor int64  `json:"minor"`
+	Rate  uint64 `json:"rate"`
+}
+
+// LinuxHugepageLimit 的 PageSize 形如 2MB，Limit 的单位为字节
+type LinuxHugepageLimit struct {
+	PageSize string `json:"pageSize"`
+	Limit    uint64 `json:"limit"`
+}
+
+// Load 读取 bundle 目录下的 config.json，并检查 my-runc 运行容器所必需的字段
+func Load(bundle string) (*Spec, error) {
+	configPath := path.Join(bundle, ConfigName)
+	body, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %v: %v", configPath, err)
+	}
+	s := &Spec{}
+	if err = json.Unmarshal(body, s); err != nil {
+		return nil, fmt.Errorf("invalid %v: %v", configPath, err)
+	}
+	if err = s.validate(); err != nil {
+		return nil, fmt.Errorf("invalid %v: %v", configPath, err)
+	}
+	return s, nil
+}
+
+func (s *Spec) validate() error {
+	if strings.SplitN(s.Version, ".", 2)[0] != strings.SplitN(Version, ".", 2)[0] {
+		return fmt.Errorf("unsupported ociVersion %q, expect %v", s.Version, Version)
+	}
+	if s.Process == nil {
+		return fmt.Errorf("process is required")
+	}
+	if len(s.Process.Cwd) > 0 && !path.IsAbs(s.Process.Cwd) {
+		return fmt.Errorf("process.cwd %v must be absolute", s.Process.Cwd)
+	}
+	if s.Root == nil || len(s.Root.Path) == 0 {
+		return fmt.Errorf("root.path is required")
+	}
+	for _, m := range s.Mounts {
+		if !path.IsAbs(m.Destination) {
+			return fmt.Errorf("destination of mount %v must be absolute", m.Destination)
+		}
+	}
+	return nil
+}
+
+// RootfsPath 返回根文件系统在宿主机上的路径
+func (s *Spec) RootfsPath(bundle string) string {
+	if path.IsAbs(s.Root.Path) {
+		return s.Root.Path
+	}
+	return path.Join(bundle, s.Root.Path)
+}
+
+// Example 返回 spec 命令生成的默认配置：在独立的 namespace 中以 root 身份执行 sh，根文件系统只读。
+// /dev/pts 由 my-runc 在每个容器中挂载，不需要出现在 mounts 中
+func Example() *Spec {
+	return &Spec{
+		Version: Version,
+		Process: &Process{
+			Terminal: true,
+			Args:     []string{"sh"},
+			Env: []string{
+				"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+				"TERM=xterm",
+			},
+			Cwd: "/",
+			Rlimits: []POSIXRlimit{
+				{Type: "RLIMIT_NOFILE", Hard: 1024, Soft: 1024},
+			},
+		},
+		Root: &Root{
+			Path:     DefaultRootfs,
+			Readonly: true,
+		},
+		Hostname: "my-runc",
+		Mounts: []Mount{
+			{Destination: "/proc", Type: "proc", Source: "proc"},
+			{
+				Destination: "/dev",
+				Type:        "tmpfs",
+				Source:      "tmpfs",
+				Options:     []string{"nosuid", "strictatime", "mode=755", "size=65536k"},
+			},
+			{
+				Destination: "/dev/shm",
+				Type:        "tmpfs",
+				Source:      "shm",
+				Options:     []string{"nosuid", "noexec", "nodev", "mode=1777", "size=65536k"},
+			},
+			{
+				Destination: "/dev/mqueue",
+				Type:        "mqueue",
+				Source:      "mqueue",
+				Options:     []string{"nosuid", "noexec", "nodev"},
+			},
+			{
+				Destination: "/sys",
+				Type:        "sysfs",
+				Source:      "sysfs",
+				Options:     []string{"nosuid", "noexec", "nodev", "ro"},
+			},
+		},
+		Linux: &Linux{
+			Resources: &LinuxResources{
+				Devices: []LinuxDeviceCgroup{
+					{Allow: false, Access: "rwm"},
+				},
+			},
+			Namespaces: []LinuxNamespace{
+				{Type: PIDNamespace},
+				{Type: NetworkNamespace},
+				{Type: IPCNamespace},
+				{Type: UTSNamespace},
+				{Type: MountNamespace},
+				{Type: CgroupNamespace},
+			},
+		},
+	}
+}
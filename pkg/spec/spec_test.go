@@ -0,0 +1,45 @@
+package spec
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMountOptions(t *testing.T) {
+	flags, data := ParseMountOptions([]string{"nosuid", "ro", "rprivate", "mode=755", "size=65536k"})
+	assert.Equal(t, uintptr(syscall.MS_NOSUID|syscall.MS_RDONLY), flags)
+	assert.Equal(t, "mode=755,size=65536k", data)
+
+	flags, data = ParseMountOptions([]string{"rbind", "ro", "rw"})
+	assert.Equal(t, uintptr(syscall.MS_BIND|syscall.MS_REC), flags)
+	assert.Equal(t, "", data)
+}
+
+func TestLoad(t *testing.T) {
+	bundle, err := ioutil.TempDir("", "bundle")
+	assert.Equal(t, nil, err)
+	defer func() {
+		_ = os.RemoveAll(bundle)
+	}()
+
+	body, err := json.Marshal(Example())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, ioutil.WriteFile(path.Join(bundle, ConfigName), body, 0644))
+	s, err := Load(bundle)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, Example(), s)
+	assert.Equal(t, path.Join(bundle, DefaultRootfs), s.RootfsPath(bundle))
+
+	s.Version = "2.0.0"
+	body, err = json.Marshal(s)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, ioutil.WriteFile(path.Join(bundle, ConfigName), body, 0644))
+	_, err = Load(bundle)
+	assert.NotEqual(t, nil, err)
+}
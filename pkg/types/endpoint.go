@@ -32,6 +32,14 @@ func (e *Endpoint) GetIPNet() *net.IPNet {
 	}
 }
 
+// HostVethName 返回 veth pair 在宿主机一端的设备名称
+func (e *Endpoint) HostVethName() string {
+	if len(e.ID) < 5 {
+		return e.ID
+	}
+	return e.ID[:5]
+}
+
 func GenerateEndpointID(metadataID, networkName string) string {
 	return metadataID + "-" + networkName
 }
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"fmt"
 	"net"
 	"strings"
 	"syscall"
@@ -108,3 +109,16 @@ func EnterNetns(nsFD int) error {
 	logrus.Infof("succeeded in executing `setns %v`", nsFD)
 	return nil
 }
+
+// GetInterfaceStatistics 返回 network interface 的收发统计
+func GetInterfaceStatistics(name string) (*netlink.LinkStatistics, error) {
+	iface, err := netlink.LinkByName(name)
+	if err != nil {
+		logrus.Errorf("failed to get link %v: %v", name, err)
+		return nil, err
+	}
+	if iface.Attrs().Statistics == nil {
+		return nil, fmt.Errorf("statistics of %v is unavailable", name)
+	}
+	return iface.Attrs().Statistics, nil
+}
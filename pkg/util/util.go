@@ -9,11 +9,26 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// CgroupRootDir 是 cgroup 文件系统的默认挂载点
+	CgroupRootDir = "/sys/fs/cgroup"
+
+	cgroup2SuperMagic = 0x63677270
+)
+
+var (
+	cgroup2Once sync.Once
+	isCgroup2   bool
 )
 
 func init() {
@@ -32,9 +47,12 @@ func ShowProcessesInSpecifyPath(outputPath string) (string, error) {
 			return "", err
 		}
 	}
-	if _, err := os.Create(outputPath); err != nil {
+	file, err := os.Create(outputPath)
+	if err != nil {
 		return "", err
 	}
+	// 没有关闭的文件会使容器的根目录无法重新挂载为只读
+	_ = file.Close()
 	if err := ioutil.WriteFile(outputPath, []byte(""), 0777); err != nil {
 
 	}
@@ -64,7 +82,7 @@ func GetCgroupPath(subsystem, cgroupName string) (string, error) {
 			logrus.Errorf("failed to get cgroup path (%v): %v", cgroupPath, err)
 			return "", fmt.Errorf("get cgroup path (%v) failed: %v", cgroupPath, err)
 		}
-		if err = os.Mkdir(cgroupPath, os.ModePerm); err != nil {
+		if err = os.MkdirAll(cgroupPath, os.ModePerm); err != nil {
 			logrus.Errorf("failed to mkdir (%v): %v", cgroupPath, err)
 			return "", fmt.Errorf("failed to mkdir (%v): %v", cgroupPath, err)
 		}
@@ -74,6 +92,25 @@ func GetCgroupPath(subsystem, cgroupName string) (string, error) {
 }
 
 func FindCgroupMountPoint(subsystem string) (string, error) {
+	mountPoint, err := findCgroupMountPoint(subsystem)
+	if err != nil {
+		return "", err
+	}
+	if len(mountPoint) == 0 {
+		logrus.Errorf("subsystem %v is not found", subsystem)
+		return "", fmt.Errorf("subsystem %v is not found", subsystem)
+	}
+	return mountPoint, nil
+}
+
+// IsCgroupMounted 判断 cgroup v1 的 subsystem 是否已经挂载，用于可选的 subsystem（如 hugetlb），没有挂载时不打印错误日志
+func IsCgroupMounted(subsystem string) bool {
+	mountPoint, err := findCgroupMountPoint(subsystem)
+	return err == nil && len(mountPoint) > 0
+}
+
+// findCgroupMountPoint 从 /proc/self/mountinfo 中查找 subsystem 的挂载点，没有挂载时返回空字符串
+func findCgroupMountPoint(subsystem string) (string, error) {
 	f, err := os.Open("/proc/self/mountinfo")
 	if err != nil {
 		return "", fmt.Errorf("open /proc/self/mountinfo err: %v", err)
@@ -99,8 +136,65 @@ func FindCgroupMountPoint(subsystem string) (string, error) {
 		logrus.Errorf("file scanner err: %v", err)
 		return "", fmt.Errorf("file scanner err: %v", err)
 	}
-	logrus.Errorf("subsystem %v is not found", subsystem)
-	return "", fmt.Errorf("subsystem %v is not found", subsystem)
+	return "", nil
+}
+
+// IsCgroup2UnifiedMode 判断当前主机是否只挂载了 cgroup v2（unified hierarchy），结果会被缓存
+func IsCgroup2UnifiedMode() bool {
+	cgroup2Once.Do(func() {
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(CgroupRootDir, &st); err != nil {
+			logrus.Warningf("failed to statfs %v: %v", CgroupRootDir, err)
+			return
+		}
+		isCgroup2 = st.Type == cgroup2SuperMagic
+	})
+	return isCgroup2
+}
+
+// ParseBytes 将带单位的大小解析为字节数，支持 b/k/m/g/t 及其 kb/mb 等写法（大小写不敏感，按 1024 进制），"-1" 表示不限制
+func ParseBytes(size string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(size))
+	if s == "-1" {
+		return -1, nil
+	}
+	s = strings.TrimSuffix(s, "b")
+	multiplier := int64(1)
+	if len(s) > 0 {
+		switch s[len(s)-1] {
+		case 'k':
+			multiplier = 1 << 10
+		case 'm':
+			multiplier = 1 << 20
+		case 'g':
+			multiplier = 1 << 30
+		case 't':
+			multiplier = 1 << 40
+		}
+	}
+	if multiplier != 1 {
+		s = s[:len(s)-1]
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size: %q", size)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// HumanSize 将字节数转换为易读的格式，如：12.5MiB
+func HumanSize(size uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+	value := float64(size)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d%v", size, units[i])
+	}
+	return fmt.Sprintf("%.4g%v", value, units[i])
 }
 
 func EnsureDirectory(targetPath string) error {
@@ -121,3 +215,66 @@ func RandomString(n int) string {
 	}
 	return buf.String()
 }
+
+// IsProcessAlive 判断进程是否仍在运行，已经退出但尚未被回收的僵尸进程视为不在运行
+func IsProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	if err := syscall.Kill(pid, 0); err == syscall.ESRCH {
+		return false
+	}
+	// /proc/${pid}/stat 的格式为 "pid (comm) state ..."，comm 中可能包含空格和括号
+	body, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return !os.IsNotExist(err)
+	}
+	stat := string(body)
+	idx := strings.LastIndex(stat, ")")
+	if idx < 0 || idx+2 >= len(stat) {
+		return true
+	}
+	return stat[idx+2] != 'Z'
+}
+
+// GetProcessStartTime 返回进程的启动时间（系统启动后的时钟周期数），即 /proc/${pid}/stat 的第 22 列。
+// pid 被回收并分配给新进程之后启动时间会不同，可以据此判断 pid 是否还是原来的进程
+func GetProcessStartTime(pid int) (uint64, error) {
+	statPath := fmt.Sprintf("/proc/%d/stat", pid)
+	body, err := ioutil.ReadFile(statPath)
+	if err != nil {
+		return 0, err
+	}
+	return parseProcessStartTime(string(body))
+}
+
+func parseProcessStartTime(stat string) (uint64, error) {
+	// comm 之后的第一列是第 3 列 state
+	idx := strings.LastIndex(stat, ")")
+	if idx < 0 {
+		return 0, fmt.Errorf("invalid process stat %q", stat)
+	}
+	fields := strings.Fields(stat[idx+1:])
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("invalid process stat %q", stat)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// ParseSignal 解析信号，支持 9、KILL、SIGKILL 等写法，大小写不敏感
+func ParseSignal(signal string) (syscall.Signal, error) {
+	s := strings.ToUpper(strings.TrimSpace(signal))
+	if num, err := strconv.Atoi(s); err == nil {
+		if num <= 0 || len(unix.SignalName(syscall.Signal(num))) == 0 {
+			return 0, fmt.Errorf("invalid signal: %v", signal)
+		}
+		return syscall.Signal(num), nil
+	}
+	if !strings.HasPrefix(s, "SIG") {
+		s = "SIG" + s
+	}
+	if sig := unix.SignalNum(s); sig != 0 {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("invalid signal: %v", signal)
+}
@@ -0,0 +1,60 @@
+package util
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBytes(t *testing.T) {
+	cases := map[string]int64{
+		"1024": 1024,
+		"100m": 100 << 20,
+		"100M": 100 << 20,
+		"1kb":  1 << 10,
+		"1.5g": 3 << 29,
+		"2GB":  2 << 30,
+		"-1":   -1,
+	}
+	for size, expected := range cases {
+		actual, err := ParseBytes(size)
+		assert.Equal(t, nil, err, size)
+		assert.Equal(t, expected, actual, size)
+	}
+
+	for _, invalid := range []string{"", "m", "abc", "-2m", "10x"} {
+		_, err := ParseBytes(invalid)
+		assert.NotEqual(t, nil, err, invalid)
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	cases := map[string]syscall.Signal{
+		"9":       syscall.SIGKILL,
+		"KILL":    syscall.SIGKILL,
+		"sigterm": syscall.SIGTERM,
+		"SIGUSR1": syscall.SIGUSR1,
+		"hup":     syscall.SIGHUP,
+	}
+	for signal, expected := range cases {
+		actual, err := ParseSignal(signal)
+		assert.Equal(t, nil, err, signal)
+		assert.Equal(t, expected, actual, signal)
+	}
+
+	for _, invalid := range []string{"", "0", "-1", "999", "SIGFOO"} {
+		_, err := ParseSignal(invalid)
+		assert.NotEqual(t, nil, err, invalid)
+	}
+}
+
+func TestParseProcessStartTime(t *testing.T) {
+	stat := "31876 (my (runc) x) S 1 31876 31876 0 -1 4194560 1234 0 0 0 1 2 0 0 20 0 1 0 8888 12345678 900"
+	startTime, err := parseProcessStartTime(stat)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(8888), startTime)
+
+	_, err = parseProcessStartTime("31876 (sh) S 1 31876")
+	assert.NotEqual(t, nil, err)
+}